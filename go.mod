@@ -3,7 +3,7 @@ module github.com/ktr0731/grpc-web-go-client
 require (
 	github.com/golang/protobuf v1.4.0-rc.4.0.20200313231945-b860323f09d0
 	github.com/google/go-cmp v0.4.0
-	github.com/gorilla/websocket v1.4.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/ktr0731/grpc-test v0.1.4
 	github.com/pkg/errors v0.9.1
 	go.uber.org/atomic v1.6.0
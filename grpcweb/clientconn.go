@@ -0,0 +1,138 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientConnInterface returns grpc.ClientConnInterface that sends RPCs via c.
+// It allows clients generated by protoc-gen-go-grpc to call gRPC-Web servers.
+// Server streaming RPCs are sent over HTTP, client and bidi streaming RPCs are sent over WebSocket.
+//
+// grpc.Header, grpc.Trailer, grpc.Peer, grpc.CallContentSubtype, grpc.ForceCodec, grpc.UseCompressor,
+// grpc.MaxCallRecvMsgSize, grpc.MaxCallSendMsgSize and grpc.PerRPCCredentials
+// are converted to the corresponding CallOptions. Other grpc.CallOptions are ignored.
+func (c *ClientConn) ClientConnInterface() grpc.ClientConnInterface {
+	return &clientConnInterface{cc: c}
+}
+
+type clientConnInterface struct {
+	cc *ClientConn
+}
+
+func (c *clientConnInterface) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return c.cc.Invoke(ctx, method, args, reply, fromGRPCCallOptions(opts)...)
+}
+
+func (c *clientConnInterface) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	callOpts := fromGRPCCallOptions(opts)
+	stream, err := c.cc.newStream(ctx, desc, method, callOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &clientStreamAdapter{
+		ctx:           ctx,
+		stream:        stream,
+		serverStreams: desc.ServerStreams,
+		callOptions:   c.cc.applyCallOptions(callOpts),
+	}, nil
+}
+
+func fromGRPCCallOptions(opts []grpc.CallOption) []CallOption {
+	var callOpts []CallOption
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			callOpts = append(callOpts, Header(o.HeaderAddr))
+		case grpc.TrailerCallOption:
+			callOpts = append(callOpts, Trailer(o.TrailerAddr))
+		case grpc.PeerCallOption:
+			callOpts = append(callOpts, Peer(o.PeerAddr))
+		case grpc.ContentSubtypeCallOption:
+			callOpts = append(callOpts, CallContentSubtype(o.ContentSubtype))
+		case grpc.ForceCodecCallOption:
+			codec := o.Codec
+			callOpts = append(callOpts, func(opt *callOptions) {
+				opt.codec = codec
+			})
+		case grpc.CompressorCallOption:
+			callOpts = append(callOpts, UseCompressor(o.CompressorType))
+		case grpc.MaxRecvMsgSizeCallOption:
+			callOpts = append(callOpts, MaxCallRecvMsgSize(o.MaxRecvMsgSize))
+		case grpc.MaxSendMsgSizeCallOption:
+			callOpts = append(callOpts, MaxCallSendMsgSize(o.MaxSendMsgSize))
+		case grpc.PerRPCCredsCallOption:
+			callOpts = append(callOpts, PerRPCCredentials(o.Creds))
+		}
+	}
+	return callOpts
+}
+
+// clientStreamAdapter adapts Stream to grpc.ClientStream.
+type clientStreamAdapter struct {
+	ctx           context.Context
+	stream        Stream
+	serverStreams bool
+	callOptions   *callOptions
+
+	finished bool
+}
+
+func (s *clientStreamAdapter) Header() (metadata.MD, error) {
+	return s.stream.Header()
+}
+
+func (s *clientStreamAdapter) Trailer() metadata.MD {
+	if !s.finished {
+		return nil
+	}
+	return s.stream.Trailer()
+}
+
+func (s *clientStreamAdapter) CloseSend() error {
+	return s.stream.CloseSend()
+}
+
+func (s *clientStreamAdapter) Context() context.Context {
+	return s.ctx
+}
+
+func (s *clientStreamAdapter) SendMsg(m interface{}) error {
+	return s.stream.Send(s.ctx, m)
+}
+
+func (s *clientStreamAdapter) RecvMsg(m interface{}) error {
+	err := s.stream.Receive(s.ctx, m)
+	if err == nil && !s.serverStreams {
+		// Same as grpc/grpc-go, make sure that the stream has been finished for non server streaming RPCs.
+		err = s.stream.Receive(s.ctx, m)
+		if err == nil {
+			err = status.Error(codes.Internal, "cardinality violation: expected <EOF> for non server-streaming RPCs, but received another message")
+		} else if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil || !s.serverStreams {
+		s.finish()
+	}
+	return err
+}
+
+// finish sets the header and trailer to the destinations specified by Header and Trailer.
+func (s *clientStreamAdapter) finish() {
+	s.finished = true
+	if s.callOptions.header != nil {
+		if h, err := s.stream.Header(); err == nil {
+			*s.callOptions.header = h
+		}
+	}
+	if s.callOptions.trailer != nil {
+		*s.callOptions.trailer = s.stream.Trailer()
+	}
+}
@@ -0,0 +1,201 @@
+// Package frame provides an encoder and a decoder for the gRPC-Web wire format.
+//
+// spec: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+package frame
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HeaderLen is the length of a frame header, which consists of a flag (1 byte) and a payload length (4 bytes).
+const HeaderLen = 5
+
+// Flags of the frame header.
+const (
+	// FlagCompressed indicates that the payload is compressed.
+	FlagCompressed byte = 0x01
+	// FlagTrailer indicates that the frame is a trailer frame.
+	FlagTrailer byte = 0x80
+)
+
+// Frame is a message frame or a trailer frame.
+type Frame struct {
+	Flag    byte
+	Payload []byte
+}
+
+// IsMessage reports whether f is a message frame.
+func (f *Frame) IsMessage() bool {
+	return f.Flag&FlagTrailer == 0
+}
+
+// IsTrailer reports whether f is a trailer frame.
+func (f *Frame) IsTrailer() bool {
+	return f.Flag&FlagTrailer == FlagTrailer
+}
+
+// IsCompressed reports whether the payload of f is compressed.
+func (f *Frame) IsCompressed() bool {
+	return f.Flag&FlagCompressed == FlagCompressed
+}
+
+// Encoder writes frames to the underlying writer.
+type Encoder struct {
+	w    io.Writer
+	text bool
+}
+
+// NewEncoder returns a new Encoder which writes binary frames (application/grpc-web) to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// NewTextEncoder returns a new Encoder which writes base64 encoded frames (application/grpc-web-text) to w.
+// Each frame is encoded separately with padding.
+func NewTextEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, text: true}
+}
+
+// Encode writes f.
+func (e *Encoder) Encode(f *Frame) error {
+	b := make([]byte, HeaderLen+len(f.Payload))
+	b[0] = f.Flag
+	binary.BigEndian.PutUint32(b[1:HeaderLen], uint32(len(f.Payload)))
+	copy(b[HeaderLen:], f.Payload)
+	if e.text {
+		b = []byte(base64.StdEncoding.EncodeToString(b))
+	}
+	if _, err := e.w.Write(b); err != nil {
+		return errors.Wrap(err, "failed to write the frame")
+	}
+	return nil
+}
+
+// EncodeMessage writes a message frame which has b as the payload.
+// compressed should be true if b is compressed.
+func (e *Encoder) EncodeMessage(b []byte, compressed bool) error {
+	var flag byte
+	if compressed {
+		flag |= FlagCompressed
+	}
+	return e.Encode(&Frame{Flag: flag, Payload: b})
+}
+
+// EncodeTrailer writes a trailer frame which consists of st and md.
+func (e *Encoder) EncodeTrailer(st *status.Status, md metadata.MD) error {
+	b, err := MarshalTrailer(st, md)
+	if err != nil {
+		return err
+	}
+	return e.Encode(&Frame{Flag: FlagTrailer, Payload: b})
+}
+
+// Decoder reads frames from the underlying reader.
+type Decoder struct {
+	// MaxMessageSize is the maximum payload length of message frames.
+	// The default is math.MaxUint32, which means no limit.
+	MaxMessageSize uint32
+	// MaxTrailerSize is the maximum payload length of trailer frames.
+	// The default is math.MaxUint32, which means no limit.
+	MaxTrailerSize uint32
+
+	r *parser.FrameReader
+}
+
+// SizeError is returned by Decoder.Decode if the payload length exceeds MaxMessageSize or MaxTrailerSize.
+type SizeError = parser.FrameSizeError
+
+// NewDecoder returns a new Decoder which reads binary frames (application/grpc-web) from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		MaxMessageSize: math.MaxUint32,
+		MaxTrailerSize: math.MaxUint32,
+		r:              parser.NewFrameReader(r),
+	}
+}
+
+// NewTextDecoder returns a new Decoder which reads base64 encoded frames (application/grpc-web-text) from r.
+func NewTextDecoder(r io.Reader) *Decoder {
+	return NewDecoder(parser.NewBase64Reader(r))
+}
+
+// Decode reads the next frame. The payload is valid until the next call of Decode.
+// It returns io.EOF if there are no more frames, and io.ErrUnexpectedEOF if a frame is truncated.
+func (d *Decoder) Decode() (*Frame, error) {
+	d.r.MaxMessageSize, d.r.MaxTrailerSize = d.MaxMessageSize, d.MaxTrailerSize
+	h, b, err := d.r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Flag: h.Flag(), Payload: b}, nil
+}
+
+// MarshalTrailer serializes st and md into the payload of a trailer frame.
+// Values of binary keys, which have "-bin" suffix, are encoded in base64.
+func MarshalTrailer(st *status.Status, md metadata.MD) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "grpc-status: %d\r\n", st.Code())
+	if msg := st.Message(); msg != "" {
+		fmt.Fprintf(&buf, "grpc-message: %s\r\n", encodeGRPCMessage(msg))
+	}
+	if p := st.Proto(); len(p.GetDetails()) != 0 {
+		b, err := proto.Marshal(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal the status details")
+		}
+		fmt.Fprintf(&buf, "grpc-status-details-bin: %s\r\n", parser.EncodeBinHeader(b))
+	}
+
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range md[k] {
+			if parser.IsBinHeader(k) {
+				v = parser.EncodeBinHeader([]byte(v))
+			}
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseTrailer parses the payload of a trailer frame.
+// The header list size is limited by parser.DefaultMaxHeaderListSize.
+func ParseTrailer(b []byte) (*status.Status, metadata.MD, error) {
+	return ParseTrailerWithLimit(b, parser.DefaultMaxHeaderListSize)
+}
+
+// ParseTrailerWithLimit is the same as ParseTrailer, but it limits the header list size to maxHeaderListSize.
+func ParseTrailerWithLimit(b []byte, maxHeaderListSize uint32) (*status.Status, metadata.MD, error) {
+	return parser.ParseStatusAndTrailerWithLimit(bytes.NewReader(b), uint32(len(b)), maxHeaderListSize)
+}
+
+// encodeGRPCMessage percent-encodes msg. It is mostly copied from http_util.go in grpc/grpc-go.
+func encodeGRPCMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
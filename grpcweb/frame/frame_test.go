@@ -0,0 +1,119 @@
+package frame_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/frame"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	st, err := status.New(codes.Internal, "internal error").WithDetails(&errdetails.DebugInfo{Detail: "detail"})
+	if err != nil {
+		t.Fatalf("WithDetails should not return an error, but got '%s'", err)
+	}
+	md := metadata.Pairs("key1", "val1", "key1", "val2", "key2", "val3")
+
+	cases := map[string]struct {
+		newEncoder func(io.Writer) *frame.Encoder
+		newDecoder func(io.Reader) *frame.Decoder
+	}{
+		"binary": {newEncoder: frame.NewEncoder, newDecoder: frame.NewDecoder},
+		"text":   {newEncoder: frame.NewTextEncoder, newDecoder: frame.NewTextDecoder},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := c.newEncoder(&buf)
+			if err := enc.EncodeMessage([]byte("hello"), false); err != nil {
+				t.Fatalf("EncodeMessage should not return an error, but got '%s'", err)
+			}
+			if err := enc.EncodeMessage([]byte("compressed"), true); err != nil {
+				t.Fatalf("EncodeMessage should not return an error, but got '%s'", err)
+			}
+			if err := enc.EncodeMessage(nil, false); err != nil {
+				t.Fatalf("EncodeMessage should not return an error, but got '%s'", err)
+			}
+			if err := enc.EncodeTrailer(st, md); err != nil {
+				t.Fatalf("EncodeTrailer should not return an error, but got '%s'", err)
+			}
+
+			dec := c.newDecoder(&buf)
+			for _, expected := range []struct {
+				payload    string
+				compressed bool
+			}{{"hello", false}, {"compressed", true}, {"", false}} {
+				f, err := dec.Decode()
+				if err != nil {
+					t.Fatalf("Decode should not return an error, but got '%s'", err)
+				}
+				if !f.IsMessage() || f.IsTrailer() {
+					t.Errorf("the frame should be a message frame, but flag is %x", f.Flag)
+				}
+				if f.IsCompressed() != expected.compressed {
+					t.Errorf("expected IsCompressed is %t, but got %t", expected.compressed, f.IsCompressed())
+				}
+				if string(f.Payload) != expected.payload {
+					t.Errorf("expected payload is '%s', but got '%s'", expected.payload, f.Payload)
+				}
+			}
+
+			f, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("Decode should not return an error, but got '%s'", err)
+			}
+			if !f.IsTrailer() {
+				t.Fatalf("the frame should be a trailer frame, but flag is %x", f.Flag)
+			}
+			gotStatus, gotMD, err := frame.ParseTrailer(f.Payload)
+			if err != nil {
+				t.Fatalf("ParseTrailer should not return an error, but got '%s'", err)
+			}
+			if diff := cmp.Diff(st.Proto(), gotStatus.Proto(), protocmp.Transform()); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+			if diff := cmp.Diff(md, gotMD); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+
+			if _, err := dec.Decode(); err != io.EOF {
+				t.Errorf("Decode should return io.EOF, but got '%v'", err)
+			}
+		})
+	}
+}
+
+func TestMarshalTrailer(t *testing.T) {
+	md := metadata.Pairs("b-key", "val", "a-key-bin", "\x00\x01")
+	b, err := frame.MarshalTrailer(status.New(codes.NotFound, "not found: 100%"), md)
+	if err != nil {
+		t.Fatalf("MarshalTrailer should not return an error, but got '%s'", err)
+	}
+	expected := "grpc-status: 5\r\n" +
+		"grpc-message: not found: 100%25\r\n" +
+		"a-key-bin: AAE\r\n" +
+		"b-key: val\r\n"
+	if diff := cmp.Diff(expected, string(b)); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+
+	st, trailer, err := frame.ParseTrailer(b)
+	if err != nil {
+		t.Fatalf("ParseTrailer should not return an error, but got '%s'", err)
+	}
+	if msg := st.Message(); msg != "not found: 100%" {
+		t.Errorf("expected message is 'not found: 100%%', but got '%s'", msg)
+	}
+	if diff := cmp.Diff(md, trailer); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
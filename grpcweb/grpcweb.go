@@ -3,21 +3,37 @@ package grpcweb
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/frame"
 	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
 	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
 	"github.com/pkg/errors"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type ClientConn struct {
-	host        string
-	dialOptions *dialOptions
+	host           string
+	dialOptions    *dialOptions
+	connectOptions *transport.ConnectOptions
+
+	// httpClient is the HTTP client owned by the ClientConn.
+	// It is nil if the client is specified by WithHTTPClient.
+	httpClient *http.Client
+	closed     atomic.Bool
+	// done is closed by Close to cancel RPCs in flight.
+	done chan struct{}
 }
 
 func DialContext(host string, opts ...DialOption) (*ClientConn, error) {
@@ -25,147 +41,655 @@ func DialContext(host string, opts ...DialOption) (*ClientConn, error) {
 	for _, o := range opts {
 		o(&opt)
 	}
-	return &ClientConn{
+	if opt.insecure && opt.transportCredentials != nil {
+		return nil, errors.New("WithInsecure and WithTransportCredentials are mutually exclusive")
+	}
+	if opt.tlsConfig != nil && (opt.insecure || opt.transportCredentials != nil) {
+		return nil, errors.New("WithTLSConfig cannot be used with WithInsecure or WithTransportCredentials")
+	}
+	if opt.tls.enabled() {
+		if opt.insecure || opt.transportCredentials != nil {
+			return nil, errors.New("TLS DialOptions cannot be used with WithInsecure or WithTransportCredentials")
+		}
+		cfg, err := opt.tls.config(opt.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		opt.tlsConfig = cfg
+	}
+	if opt.insecure {
+		for _, creds := range opt.perRPCCreds {
+			if creds.RequireTransportSecurity() {
+				return nil, errCredentialsConflict
+			}
+		}
+	}
+	if opt.retryPolicy != nil {
+		if err := opt.retryPolicy.validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid retry policy")
+		}
+	}
+	for method, p := range opt.methodRetryPolicies {
+		if err := p.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid retry policy for '%s'", method)
+		}
+	}
+	chainUnaryClientInterceptors(&opt)
+	chainStreamClientInterceptors(&opt)
+	cc := &ClientConn{
 		host:        host,
 		dialOptions: &opt,
-	}, nil
+		done:        make(chan struct{}),
+		connectOptions: &transport.ConnectOptions{
+			Insecure:             opt.insecure,
+			TransportCredentials: opt.transportCredentials,
+			TLSConfig:            opt.tlsConfig,
+			HTTPClient:           opt.httpClient,
+			WebSocketDialer:      opt.webSocketDialer,
+			ContextDialer:        opt.contextDialer,
+		},
+	}
+	if cc.connectOptions.HTTPClient == nil {
+		// Connections of the client are reused by all RPCs of the ClientConn until Close is called.
+		cc.httpClient = transport.NewHTTPClient(cc.connectOptions)
+		cc.connectOptions.HTTPClient = cc.httpClient
+	}
+	return cc, nil
+}
+
+// Close releases the connections owned by c.
+// Connections of the HTTP client specified by WithHTTPClient are not closed.
+// After Close is called, all RPCs fail with codes.Canceled, and RPCs and streams in flight are canceled.
+func (c *ClientConn) Close() error {
+	if c.closed.Swap(true) {
+		return errClientConnClosing
+	}
+	close(c.done)
+	if c.httpClient != nil {
+		c.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// newRPCContext returns a context for an RPC, which is canceled when ctx is done or c is closed.
+// cancel must be called after the RPC is finished.
+func (c *ClientConn) newRPCContext(ctx context.Context) (_ context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
+// errCredentialsConflict is same as the error of grpc.Dial for the credentials which require transport security.
+var errCredentialsConflict = errors.New("grpc: the credentials require transport level security (use WithTransportCredentials() to set)")
+
+// errClientConnClosing is same as grpc.ErrClientConnClosing.
+var errClientConnClosing = status.Error(codes.Canceled, "grpc: the client connection is closing")
+
 func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...CallOption) error {
-	callOptions := c.applyCallOptions(opts)
-	codec := callOptions.codec
+	if c.closed.Load() {
+		return errClientConnClosing
+	}
+	if c.dialOptions.unaryInt != nil {
+		return c.dialOptions.unaryInt(ctx, method, args, reply, c, invoke, opts...)
+	}
+	return invoke(ctx, method, args, reply, c, opts...)
+}
 
-	tr := transport.NewUnary(c.host, nil)
-	defer tr.Close()
+func invoke(ctx context.Context, method string, args, reply interface{}, c *ClientConn, opts ...CallOption) (err error) {
+	ctx, cancel := c.newRPCContext(ctx)
+	defer func() {
+		err = toStatusError(contextError(ctx, err))
+		cancel()
+	}()
+
+	callOptions := c.applyCallOptions(opts)
+	if err := callOptions.checkCodec(); err != nil {
+		return err
+	}
 
-	r, err := encodeRequestBody(codec, args)
+	body, err := encodeRequestBody(callOptions, args)
 	if err != nil {
 		return errors.Wrap(err, "failed to build the request body")
 	}
 
-	md, ok := metadata.FromOutgoingContext(ctx)
-	if ok {
-		for k, v := range md {
-			for _, vv := range v {
-				tr.Header().Add(k, vv)
-			}
+	retry := &retryState{policy: c.dialOptions.retryPolicyFor(method)}
+	for {
+		trailer, committed, err := invokeAttempt(ctx, method, body, reply, c, callOptions, retry.attempts)
+		if err == nil || committed || !retry.retry(ctx, err, trailer) {
+			return err
 		}
 	}
+}
+
+// invokeAttempt makes an attempt of the unary RPC. attempts is the number of the previous attempts.
+// It returns the trailer if it has been received for the retry policy, and committed is true if the response message has been received.
+func invokeAttempt(ctx context.Context, method string, body []byte, reply interface{}, c *ClientConn, callOptions *callOptions, attempts int) (_ metadata.MD, committed bool, _ error) {
+	codec := callOptions.codec
+
+	tr := c.newUnaryTransport()
+	defer tr.Close()
+
+	if err := setRequestHeader(ctx, c, method, callOptions, tr.Header()); err != nil {
+		return nil, false, err
+	}
+	if attempts > 0 {
+		tr.Header().Set("grpc-previous-rpc-attempts", strconv.Itoa(attempts))
+	}
 
-	contentType := "application/grpc-web+" + codec.Name()
-	header, rawBody, err := tr.Send(ctx, method, contentType, r)
+	header, rawBody, err := tr.Send(ctx, method, callOptions.contentType(), bytes.NewReader(body))
 	if err != nil {
-		return errors.Wrap(err, "failed to send the request")
+		return nil, false, errors.Wrap(err, "failed to send the request")
 	}
 	defer rawBody.Close()
+	callOptions.setPeer(tr)
 
+	resMD := toMetadata(header)
 	if callOptions.header != nil {
-		*callOptions.header = toMetadata(header)
+		*callOptions.header = resMD
 	}
 
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	dec := newDecoder(callOptions, rawBody)
+	f, err := dec.Decode()
+	if err == io.EOF {
+		// Trailers-only responses, the status and trailer are sent as the response header.
+		if callOptions.trailer != nil {
+			*callOptions.trailer = resMD
+		}
+		if st := statusFromHeader(resMD); st.Code() != codes.OK {
+			return resMD, false, st.Err()
+		}
+		return resMD, false, status.Error(codes.Internal, "grpc: no response message in the trailers-only response")
+	}
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return nil, false, receiveError(err, "failed to read the response frame")
 	}
 
-	if resHeader.IsMessageHeader() {
-		resBody, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+	if f.IsMessage() {
+		committed = true
+		resBody, err := parseMessage(callOptions, f, resMD)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse the response body")
+			return nil, committed, errors.Wrap(err, "failed to parse the response body")
 		}
 		if err := codec.Unmarshal(resBody, reply); err != nil {
-			return errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
+			return nil, committed, errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
 		}
 
-		resHeader, err = parser.ParseResponseHeader(rawBody)
+		f, err = dec.Decode()
 		if err != nil {
-			return errors.Wrap(err, "failed to parse response header")
+			return nil, committed, receiveError(err, "failed to read the trailer frame")
 		}
 	}
-	if !resHeader.IsTrailerHeader() {
-		return errors.New("unexpected header")
+	if !f.IsTrailer() {
+		return nil, committed, errors.New("unexpected header")
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+	status, trailer, err := parseStatusAndTrailer(callOptions, f, resMD)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse status and trailer")
+		return nil, committed, errors.Wrap(err, "failed to parse status and trailer")
 	}
 	if callOptions.trailer != nil {
 		*callOptions.trailer = trailer
 	}
-
-	return status.Err()
+	return trailer, committed, status.Err()
 }
 
-func (c *ClientConn) NewClientStream(desc *grpc.StreamDesc, method string, opts ...CallOption) (ClientStream, error) {
+// NewClientStream creates a new client streaming RPC.
+// ctx is bound to the stream. Outgoing metadata is taken from it, and cancelling it tears down the stream.
+func (c *ClientConn) NewClientStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (ClientStream, error) {
 	if !desc.ClientStreams {
 		return nil, errors.New("not a client stream RPC")
 	}
-	tr, err := transport.NewClientStream(c.host, method)
+	stream, err := c.newStream(ctx, desc, method, opts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create a new transport stream")
+		return nil, err
 	}
-	return &clientStream{
-		endpoint:    method,
-		transport:   tr,
-		callOptions: c.applyCallOptions(opts),
-	}, nil
+	if cs, ok := stream.(ClientStream); ok {
+		return cs, nil
+	}
+	return &interceptedClientStream{Stream: stream}, nil
 }
 
-func (c *ClientConn) NewServerStream(desc *grpc.StreamDesc, method string, opts ...CallOption) (ServerStream, error) {
+// NewServerStream creates a new server streaming RPC.
+// ctx is bound to the stream. Outgoing metadata is taken from it, and cancelling it tears down the stream.
+func (c *ClientConn) NewServerStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (ServerStream, error) {
 	if !desc.ServerStreams {
 		return nil, errors.New("not a server stream RPC")
 	}
-	return &serverStream{
-		endpoint:    method,
-		transport:   transport.NewUnary(c.host, nil),
-		callOptions: c.applyCallOptions(opts),
-	}, nil
+	return c.newStream(ctx, desc, method, opts...)
 }
 
-func (c *ClientConn) NewBidiStream(desc *grpc.StreamDesc, method string, opts ...CallOption) (BidiStream, error) {
+// NewBidiStream creates a new bidi streaming RPC.
+// ctx is bound to the stream. Outgoing metadata is taken from it, and cancelling it tears down the stream.
+func (c *ClientConn) NewBidiStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (BidiStream, error) {
 	if !desc.ServerStreams || !desc.ClientStreams {
 		return nil, errors.New("not a bidi stream RPC")
 	}
-	stream, err := c.NewClientStream(desc, method, opts...)
+	return c.newStream(ctx, desc, method, opts...)
+}
+
+// newStream creates a stream through the stream interceptors.
+func (c *ClientConn) newStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...CallOption) (Stream, error) {
+	if c.closed.Load() {
+		return nil, errClientConnClosing
+	}
+	if c.dialOptions.streamInt != nil {
+		return c.dialOptions.streamInt(ctx, desc, c, method, newStream, opts...)
+	}
+	return newStream(ctx, desc, c, method, opts...)
+}
+
+// newStream creates a stream according to desc.
+// Server streams use the HTTP transport, client and bidi streams use the WebSocket transport.
+func newStream(ctx context.Context, desc *grpc.StreamDesc, c *ClientConn, method string, opts ...CallOption) (_ Stream, err error) {
+	ctx, cancel := c.newRPCContext(ctx)
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	callOptions := c.applyCallOptions(opts)
+	if err := callOptions.checkCodec(); err != nil {
+		return nil, err
+	}
+	if !desc.ClientStreams {
+		tr := c.newUnaryTransport()
+		if err := setRequestHeader(ctx, c, method, callOptions, tr.Header()); err != nil {
+			tr.Close()
+			return nil, err
+		}
+		return &serverStream{
+			ctx:         ctx,
+			cancel:      cancel,
+			cc:          c,
+			endpoint:    method,
+			transport:   tr,
+			callOptions: callOptions,
+			reqHeader:   tr.Header().Clone(),
+			retry:       &retryState{policy: c.dialOptions.retryPolicyFor(method)},
+		}, nil
+	}
+
+	h := make(http.Header)
+	h.Set("content-type", callOptions.contentType())
+	if err := setRequestHeader(ctx, c, method, callOptions, h); err != nil {
+		return nil, err
+	}
+	tr, err := c.newClientStreamTransport(ctx, method)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create a new client stream")
+		return nil, toStatusError(contextError(ctx, errors.Wrap(err, "failed to create a new transport stream")))
 	}
-	return &bidiStream{
-		clientStream: stream.(*clientStream),
-	}, nil
+	tr.SetRequestHeader(h)
+	if l, ok := tr.(interface{ SetReadLimit(int64) }); ok {
+		// A WebSocket message contains a message frame and the trailer frame at most.
+		l.SetReadLimit(int64(callOptions.maxRecvFrameSize()) + int64(callOptions.maxHeaderListSize) + 2*frame.HeaderLen)
+	}
+	callOptions.setPeer(tr)
+	stream := &clientStream{
+		ctx:         ctx,
+		endpoint:    method,
+		transport:   tr,
+		callOptions: callOptions,
+		reqHeader:   h,
+	}
+	stop := watchContext(ctx, tr)
+	stream.stopWatch = func() {
+		stop()
+		cancel()
+	}
+	if desc.ServerStreams {
+		return &bidiStream{clientStream: stream}, nil
+	}
+	return stream, nil
+}
+
+func (c *ClientConn) newUnaryTransport() transport.UnaryTransport {
+	if f := c.dialOptions.unaryTransport; f != nil {
+		return f(c.host, c.connectOptions)
+	}
+	return transport.NewUnary(c.host, c.connectOptions)
+}
+
+func (c *ClientConn) newClientStreamTransport(ctx context.Context, endpoint string) (transport.ClientStreamTransport, error) {
+	if f := c.dialOptions.clientStreamTransport; f != nil {
+		return f(ctx, c.host, endpoint, c.connectOptions)
+	}
+	return transport.NewClientStream(ctx, c.host, endpoint, c.connectOptions)
 }
 
 func (c *ClientConn) applyCallOptions(opts []CallOption) *callOptions {
 	callOpts := append(c.dialOptions.defaultCallOptions, opts...)
 	callOptions := defaultCallOptions
+	callOptions.maxHeaderListSize = c.dialOptions.maxHeaderListSize
 	for _, o := range callOpts {
 		o(&callOptions)
 	}
 	return &callOptions
 }
 
-// copied from rpc_util.go#msgHeader
-const headerLen = 5
+// encodeRequestBody encodes in into a message frame.
+func encodeRequestBody(opts *callOptions, in interface{}) ([]byte, error) {
+	body, err := opts.codec.Marshal(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the request body")
+	}
+	comp, err := opts.compressor()
+	if err != nil {
+		return nil, err
+	}
+	if comp != nil {
+		body, err = compress(comp, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compress the request body")
+		}
+	}
+	if len(body) > opts.maxSendMsgSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc: trying to send message larger than max (%d vs. %d)", len(body), opts.maxSendMsgSize)
+	}
+	var buf bytes.Buffer
+	enc := frame.NewEncoder(&buf)
+	if opts.textFormat {
+		enc = frame.NewTextEncoder(&buf)
+	}
+	if err := enc.EncodeMessage(body, comp != nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compress(comp encoding.Compressor, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := comp.Compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setRequestHeader sets the outgoing metadata of ctx, the metadata of per-RPC credentials and
+// headers to negotiate the call with the server.
+func setRequestHeader(ctx context.Context, c *ClientConn, method string, opts *callOptions, h http.Header) error {
+	if err := setCredentialsHeader(ctx, c, method, opts, h); err != nil {
+		return err
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		for k, v := range md {
+			for _, vv := range v {
+				if parser.IsBinHeader(k) {
+					vv = parser.EncodeBinHeader([]byte(vv))
+				}
+				h.Add(k, vv)
+			}
+		}
+	}
+	setEncodingHeader(opts, h)
+	return setTimeoutHeader(ctx, h)
+}
+
+// setCredentialsHeader sets the metadata of per-RPC credentials specified by WithPerRPCCredentials and PerRPCCredentials.
+// It is mostly same as http2_client.go in grpc/grpc-go.
+func setCredentialsHeader(ctx context.Context, c *ClientConn, method string, opts *callOptions, h http.Header) error {
+	creds := c.dialOptions.perRPCCreds
+	if opts.perRPCCreds != nil {
+		if opts.perRPCCreds.RequireTransportSecurity() && c.connectOptions.Insecure {
+			return status.Error(codes.Unauthenticated, "transport: cannot send secure credentials on an insecure connection")
+		}
+		creds = append(creds[:len(creds):len(creds)], opts.perRPCCreds)
+	}
+	if len(creds) == 0 {
+		return nil
+	}
+
+	// The audience is the URI of the service.
+	pos := strings.LastIndex(method, "/")
+	if pos == -1 {
+		pos = len(method)
+	}
+	audience := "https://" + strings.TrimSuffix(c.host, ":443") + method[:pos]
+	for _, cred := range creds {
+		md, err := cred.GetRequestMetadata(ctx, audience)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			return status.Errorf(codes.Unauthenticated, "transport: %v", err)
+		}
+		for k, v := range md {
+			// Same as grpc/grpc-go, keys are lowercased.
+			k = strings.ToLower(k)
+			if parser.IsBinHeader(k) {
+				v = parser.EncodeBinHeader([]byte(v))
+			}
+			h.Add(k, v)
+		}
+	}
+	return nil
+}
+
+// setEncodingHeader sets headers to negotiate the message encoding with the server.
+func setEncodingHeader(opts *callOptions, h http.Header) {
+	if !opts.compressed() {
+		return
+	}
+	h.Set("grpc-encoding", opts.compressorName)
+	h.Set("grpc-accept-encoding", opts.compressorName)
+}
+
+// setTimeoutHeader sets grpc-timeout to the remaining time until the deadline of ctx.
+// It returns a DeadlineExceeded error if the deadline has already passed.
+func setTimeoutHeader(ctx context.Context, h http.Header) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return status.Error(codes.DeadlineExceeded, context.DeadlineExceeded.Error())
+	}
+	h.Set("grpc-timeout", encodeTimeout(timeout))
+	return nil
+}
+
+// copied from http_util.go#encodeTimeout
+const maxTimeoutValue int64 = 100000000 - 1
 
-func header(body []byte) []byte {
-	h := make([]byte, 5)
-	h[0] = byte(0)
-	binary.BigEndian.PutUint32(h[1:], uint32(len(body)))
-	return h
+// div does integer division and round-up the result.
+func div(d, r time.Duration) int64 {
+	if m := d % r; m > 0 {
+		return int64(d/r + 1)
+	}
+	return int64(d / r)
+}
+
+// encodeTimeout encodes t in the format of grpc-timeout, which has at most 8 digits and a unit.
+func encodeTimeout(t time.Duration) string {
+	if t <= 0 {
+		return "0n"
+	}
+	if d := div(t, time.Nanosecond); d <= maxTimeoutValue {
+		return strconv.FormatInt(d, 10) + "n"
+	}
+	if d := div(t, time.Microsecond); d <= maxTimeoutValue {
+		return strconv.FormatInt(d, 10) + "u"
+	}
+	if d := div(t, time.Millisecond); d <= maxTimeoutValue {
+		return strconv.FormatInt(d, 10) + "m"
+	}
+	if d := div(t, time.Second); d <= maxTimeoutValue {
+		return strconv.FormatInt(d, 10) + "S"
+	}
+	if d := div(t, time.Minute); d <= maxTimeoutValue {
+		return strconv.FormatInt(d, 10) + "M"
+	}
+	// Note that maxTimeoutValue * time.Hour > MaxInt64.
+	return strconv.FormatInt(div(t, time.Hour), 10) + "H"
 }
 
-// header (compressed-flag(1) + message-length(4)) + body
-// TODO: compressed message
-func encodeRequestBody(codec encoding.Codec, in interface{}) (io.Reader, error) {
-	body, err := codec.Marshal(in)
+// decompress decompresses the payload of f by the compressor specified by grpc-encoding in the response header.
+// Same as grpc/grpc-go, it returns an error if the frame is compressed, but grpc-encoding is missing.
+// It reads at most max+1 bytes to detect that the decompressed payload exceeds max.
+func decompress(f *frame.Frame, header metadata.MD, max int) ([]byte, error) {
+	if !f.IsCompressed() {
+		return f.Payload, nil
+	}
+	var name string
+	if v := header.Get("grpc-encoding"); len(v) != 0 {
+		name = v[0]
+	}
+	if name == "" || name == "identity" {
+		return nil, status.Error(codes.Internal, "grpc: compressed flag set with identity or empty encoding")
+	}
+	comp := encoding.GetCompressor(name)
+	if comp == nil {
+		return nil, status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", name)
+	}
+	r, err := comp.Decompress(bytes.NewReader(f.Payload))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal the request body")
+		return nil, errors.Wrap(err, "failed to decompress the response")
+	}
+	if max >= 0 {
+		r = io.LimitReader(r, int64(max)+1)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// parseMessage returns the message of the message frame f.
+// header is the response header.
+func parseMessage(opts *callOptions, f *frame.Frame, header metadata.MD) ([]byte, error) {
+	b, err := decompress(f, header, opts.maxRecvMsgSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > opts.maxRecvMsgSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc: received message after decompression larger than max (%d vs. %d)", len(b), opts.maxRecvMsgSize)
+	}
+	return b, nil
+}
+
+// parseStatusAndTrailer parses the trailer frame f.
+// header is the response header.
+func parseStatusAndTrailer(opts *callOptions, f *frame.Frame, header metadata.MD) (*status.Status, metadata.MD, error) {
+	b, err := decompress(f, header, int(opts.maxHeaderListSize))
+	if err != nil {
+		return nil, nil, err
 	}
-	buf := bytes.NewBuffer(make([]byte, 0, headerLen+len(body)))
-	buf.Write(header(body))
-	buf.Write(body)
-	return buf, nil
+	st, trailer, err := frame.ParseTrailerWithLimit(b, opts.maxHeaderListSize)
+	if errors.Is(err, parser.ErrHeaderListTooLarge) {
+		return nil, nil, status.Errorf(codes.ResourceExhausted, "grpc: trailer header list size exceeds the limit %d", opts.maxHeaderListSize)
+	}
+	return st, trailer, err
+}
+
+// contextError converts err to a status error with codes.Canceled or codes.DeadlineExceeded if err
+// is caused by ctx. Same as grpc/grpc-go, the message is the error of ctx.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+	return err
+}
+
+// toStatusError converts err to an error which status.FromError can handle.
+// If err has a status in its chain, the code of the status is used. Otherwise, err is regarded as codes.Internal
+// because the remaining errors are caused by malformed responses or codecs.
+// The cause is still reachable via errors.Unwrap.
+func toStatusError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return &statusError{st: status.New(se.GRPCStatus().Code(), err.Error()), err: err}
+	}
+	return &statusError{st: status.New(codes.Internal, err.Error()), err: err}
+}
+
+// statusError is an error which has a status and the cause.
+type statusError struct {
+	st  *status.Status
+	err error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.st
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// watchContext closes c when ctx is done to abort blocked reads.
+// If c can be aborted, for example the WebSocket transport, it is aborted instead of Close,
+// which may be blocked by pending writes.
+// The returned function stops watching.
+func watchContext(ctx context.Context, c io.Closer) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if a, ok := c.(interface{ Abort(error) }); ok {
+				a.Abort(ctx.Err())
+			} else {
+				c.Close()
+			}
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// newDecoder returns a decoder which reads frames from the response body r.
+func newDecoder(opts *callOptions, r io.Reader) *frame.Decoder {
+	dec := frame.NewDecoder(r)
+	if opts.textFormat {
+		dec = frame.NewTextDecoder(r)
+	}
+	dec.MaxMessageSize = opts.maxRecvFrameSize()
+	dec.MaxTrailerSize = opts.maxHeaderListSize
+	return dec
+}
+
+// receiveError converts err to a status error with codes.ResourceExhausted if the received frame or
+// WebSocket message exceeds the limit. Otherwise, it wraps err with msg.
+func receiveError(err error, msg string) error {
+	var serr *frame.SizeError
+	switch {
+	case errors.As(err, &serr) && serr.Header.IsTrailerHeader():
+		return status.Errorf(codes.ResourceExhausted, "grpc: trailer header list size exceeds the limit %d", serr.Max)
+	case errors.As(err, &serr):
+		return status.Errorf(codes.ResourceExhausted, "grpc: received message larger than max (%d vs. %d)", serr.Header.ContentLength, serr.Max)
+	case errors.Is(err, websocket.ErrReadLimit):
+		return status.Error(codes.ResourceExhausted, "grpc: received message larger than the read limit")
+	}
+	return errors.Wrap(err, msg)
 }
 
 func toMetadata(h http.Header) metadata.MD {
@@ -173,8 +697,21 @@ func toMetadata(h http.Header) metadata.MD {
 		return nil
 	}
 	md := metadata.New(nil)
+	appendHeader(md, h)
+	return md
+}
+
+// appendHeader appends h to md. Same as gRPC, values of binary headers (keys end with "-bin") are base64-decoded.
+// Binary headers may have comma-separated values if they are combined by proxies.
+// Values which cannot be decoded are kept as they are.
+func appendHeader(md metadata.MD, h http.Header) {
 	for k, v := range h {
-		md.Append(k, v...)
+		if !parser.IsBinHeader(k) {
+			md.Append(k, v...)
+			continue
+		}
+		for _, vv := range v {
+			md.Append(k, parser.DecodeBinValues(vv)...)
+		}
 	}
-	return md
 }
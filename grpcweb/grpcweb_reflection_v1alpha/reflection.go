@@ -27,6 +27,7 @@ func (c *serverReflectionClient) ServerReflectionInfo(ctx context.Context, opts
 	}
 
 	stream, err := c.cc.NewBidiStream(
+		ctx,
 		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
 		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo")
 	if err != nil {
@@ -1,34 +1,61 @@
 package grpcweb
 
 import (
+	"bytes"
+	gz "compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
 	"github.com/ktr0731/grpc-test/api"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/frame"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/internal/wstest"
 	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 type unaryTransport struct {
 	t *testing.T
 
-	expectedMD metadata.MD
-	h          http.Header
-	r          io.ReadCloser
-	err        error
+	expectedMD          metadata.MD
+	expectedContentType string
+	h                   http.Header
+	r                   io.ReadCloser
+	err                 error
+
+	reqHeader http.Header
+	reqBody   []byte
 }
 
 func (t *unaryTransport) Header() http.Header {
-	return make(http.Header)
+	if t.reqHeader == nil {
+		t.reqHeader = make(http.Header)
+	}
+	return t.reqHeader
 }
 
 func (t *unaryTransport) Send(ctx context.Context, endpoint, contentType string, body io.Reader) (http.Header, io.ReadCloser, error) {
@@ -39,6 +66,14 @@ func (t *unaryTransport) Send(ctx context.Context, endpoint, contentType string,
 	if diff := cmp.Diff(t.expectedMD, md); diff != "" {
 		t.t.Fatalf("-want, +got\n%s", diff)
 	}
+	if t.expectedContentType != "" && t.expectedContentType != contentType {
+		t.t.Fatalf("expected content-type is '%s', but got '%s'", t.expectedContentType, contentType)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.t.Fatalf("ReadAll should not return an error, but got '%s'", err)
+	}
+	t.reqBody = b
 	return t.h, t.r, t.err
 }
 
@@ -47,6 +82,8 @@ func (t *unaryTransport) Close() error {
 }
 
 func TestInvoke(t *testing.T) {
+	t.Parallel()
+
 	header := http.Header{
 		"hakase": []string{"shinonome"},
 		"nano":   []string{"shinonome"},
@@ -102,7 +139,10 @@ func TestInvoke(t *testing.T) {
 	}
 
 	for name, c := range cases {
+		c := c
 		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
 			r, err := os.Open(filepath.Join("testdata", c.transportContentFileName))
 			if err != nil {
 				t.Fatalf("Open should not return an error, but got '%s'", err)
@@ -110,7 +150,7 @@ func TestInvoke(t *testing.T) {
 
 			md := metadata.Pairs("yuko", "aioi")
 
-			injectUnaryTransport(t, &unaryTransport{
+			trOpt := withUnaryTransport(&unaryTransport{
 				t:          t,
 				expectedMD: md,
 				h:          c.transportHeader,
@@ -118,7 +158,7 @@ func TestInvoke(t *testing.T) {
 			})
 
 			var header, trailer metadata.MD
-			client, err := DialContext(":50051")
+			client, err := DialContext(":50051", trOpt)
 			if err != nil {
 				t.Fatalf("DialContext should not return an error, but got '%s'", err)
 			}
@@ -157,17 +197,361 @@ func TestInvoke(t *testing.T) {
 	}
 }
 
-func injectUnaryTransport(t *testing.T, tr transport.UnaryTransport) {
-	old := transport.NewUnary
-	t.Cleanup(func() {
-		transport.NewUnary = old
+func TestInvokeTextFormat(t *testing.T) {
+	t.Parallel()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	trOpt := withUnaryTransport(&unaryTransport{
+		t:                   t,
+		expectedMD:          md,
+		expectedContentType: "application/grpc-web-text+proto",
+		r:                   ioutil.NopCloser(strings.NewReader(encodeFramesToText(t, b))),
 	})
-	transport.NewUnary = func(string, *transport.ConnectOptions) transport.UnaryTransport {
-		return tr
+
+	client, err := DialContext(":50051", trOpt, WithDefaultCallOptions(UseTextFormat()))
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+
+	var res api.SimpleResponse
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+		t.Fatalf("should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff(api.SimpleResponse{Message: "hello, ktr"}, res); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+}
+
+func TestStreamTextFormat(t *testing.T) {
+	t.Parallel()
+
+	const contentType = "application/grpc-web-text+proto"
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	readFile := func(t *testing.T, name string) []byte {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+		}
+		return b
+	}
+	// assertRequest asserts that the request body is a base64 encoded message frame.
+	assertRequest := func(t *testing.T, b []byte) {
+		f, err := frame.NewTextDecoder(bytes.NewReader(b)).Decode()
+		if err != nil {
+			t.Fatalf("Decode should not return an error, but got '%s'", err)
+		}
+		var req api.SimpleRequest
+		if err := proto.Unmarshal(f.Payload, &req); err != nil {
+			t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+		}
+		if req.GetName() != "nano" {
+			t.Errorf("expected name is 'nano', but got '%s'", req.GetName())
+		}
+	}
+	// assertPadded asserts that s consists of multiple padded base64 strings.
+	assertPadded := func(t *testing.T, s string) {
+		if !strings.Contains(strings.TrimRight(s, "="), "=") {
+			t.Fatalf("the body should have padding in the middle, but got '%s'", s)
+		}
+	}
+	newClient := func(t *testing.T, opt DialOption) *ClientConn {
+		client, err := DialContext(":50051", opt, WithDefaultCallOptions(UseTextFormat()))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		return client
+	}
+
+	t.Run("server stream", func(t *testing.T) {
+		t.Parallel()
+
+		// Each frame is padded separately.
+		body := encodeFramesToText(t, readFile(t, "server_stream_response.in"))
+		assertPadded(t, body)
+		tr := &unaryTransport{
+			t:                   t,
+			expectedMD:          md,
+			expectedContentType: contentType,
+			r:                   ioutil.NopCloser(strings.NewReader(body)),
+		}
+		client := newClient(t, withUnaryTransport(tr))
+
+		stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		var ress []api.SimpleResponse
+		for {
+			var res api.SimpleResponse
+			err = stm.Receive(ctx, &res)
+			if err != nil {
+				break
+			}
+			ress = append(ress, res)
+		}
+		if err != io.EOF {
+			t.Fatalf("Receive should return io.EOF, but got '%v'", err)
+		}
+		expected := []api.SimpleResponse{
+			{Message: "hello nano, I greet 1 times."},
+			{Message: "hello nano, I greet 2 times."},
+			{Message: "hello nano, I greet 3 times."},
+		}
+		if diff := cmp.Diff(expected, ress); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		assertRequest(t, tr.reqBody)
+	})
+
+	h := make(http.Header)
+	h.Add("content-type", contentType)
+	h.Add("yuko", "aioi")
+
+	t.Run("client stream", func(t *testing.T) {
+		t.Parallel()
+
+		// The message and the trailer are padded separately in a WebSocket message.
+		body := encodeFramesToText(t, readFile(t, "bidi_stream_response1.in")) +
+			encodeFramesToText(t, readFile(t, "client_stream_response2.in"))
+		assertPadded(t, body)
+		tr := &clientStreamTransport{
+			tt:             t,
+			expectedHeader: h,
+			r:              []io.ReadCloser{ioutil.NopCloser(strings.NewReader(body))},
+		}
+		client := newClient(t, withClientStreamTransport(tr))
+
+		stm, err := client.NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		var res api.SimpleResponse
+		if err := stm.CloseAndReceive(ctx, &res); err != nil {
+			t.Fatalf("CloseAndReceive should not return an error, but got '%s'", err)
+		}
+		if diff := cmp.Diff(api.SimpleResponse{Message: "hello ktr, I greet 1 times."}, res); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if len(tr.reqBodies) != 1 {
+			t.Fatalf("expected 1 request, but got %d", len(tr.reqBodies))
+		}
+		assertRequest(t, tr.reqBodies[0])
+	})
+
+	t.Run("bidi stream", func(t *testing.T) {
+		t.Parallel()
+
+		// A frame may be split into WebSocket messages, and each of them is padded.
+		var rs []io.ReadCloser
+		for _, name := range []string{"bidi_stream_response1.in", "bidi_stream_response2.in", "bidi_stream_response3.in", "bidi_stream_response4.in"} {
+			b := readFile(t, name)
+			body := base64.StdEncoding.EncodeToString(b[:1]) + base64.StdEncoding.EncodeToString(b[1:])
+			assertPadded(t, body)
+			rs = append(rs, ioutil.NopCloser(strings.NewReader(body)))
+		}
+		tr := &clientStreamTransport{
+			tt:             t,
+			expectedHeader: h,
+			r:              rs,
+		}
+		client := newClient(t, withClientStreamTransport(tr))
+
+		stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewBidiStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		if err := stm.CloseSend(); err != nil {
+			t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+		}
+		var ress []api.SimpleResponse
+		for {
+			var res api.SimpleResponse
+			err = stm.Receive(ctx, &res)
+			if err != nil {
+				break
+			}
+			ress = append(ress, res)
+		}
+		if err != io.EOF {
+			t.Fatalf("Receive should return io.EOF, but got '%v'", err)
+		}
+		expected := []api.SimpleResponse{
+			{Message: "hello ktr, I greet 1 times."},
+			{Message: "hello ktr, I greet 2 times."},
+			{Message: "hello ktr, I greet 3 times."},
+		}
+		if diff := cmp.Diff(expected, ress); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if len(tr.reqBodies) != 1 {
+			t.Fatalf("expected 1 request, but got %d", len(tr.reqBodies))
+		}
+		assertRequest(t, tr.reqBodies[0])
+	})
+}
+
+func TestInvokeCompression(t *testing.T) {
+	t.Parallel()
+
+	resBody, err := proto.Marshal(&api.SimpleResponse{Message: "hello, ktr"})
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+	var body bytes.Buffer
+	body.Write(compressedFrame(t, 0x01, resBody))
+	body.Write(compressedFrame(t, 0x81, []byte("grpc-status: 0\r\ntrailer_key1: trailer_val1\r\n")))
+
+	md := metadata.Pairs("yuko", "aioi")
+	tr := &unaryTransport{
+		t:          t,
+		expectedMD: md,
+		h:          http.Header{"grpc-encoding": []string{"gzip"}},
+		r:          ioutil.NopCloser(&body),
+	}
+	trOpt := withUnaryTransport(tr)
+
+	client, err := DialContext(":50051", trOpt)
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+
+	var (
+		res     api.SimpleResponse
+		trailer metadata.MD
+	)
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, UseCompressor(gzip.Name), Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("should not return an error, but got '%s'", err)
+	}
+	if diff := cmp.Diff(api.SimpleResponse{Message: "hello, ktr"}, res); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if diff := cmp.Diff(metadata.Pairs("trailer_key1", "trailer_val1"), trailer); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+
+	if v := tr.reqHeader.Get("grpc-encoding"); v != gzip.Name {
+		t.Errorf("expected grpc-encoding is '%s', but got '%s'", gzip.Name, v)
+	}
+	if v := tr.reqHeader.Get("grpc-accept-encoding"); v != gzip.Name {
+		t.Errorf("expected grpc-accept-encoding is '%s', but got '%s'", gzip.Name, v)
+	}
+	if len(tr.reqBody) < 5 || tr.reqBody[0] != 0x01 {
+		t.Fatalf("the request message should be compressed")
+	}
+	r, err := gz.NewReader(bytes.NewReader(tr.reqBody[5:]))
+	if err != nil {
+		t.Fatalf("NewReader should not return an error, but got '%s'", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll should not return an error, but got '%s'", err)
+	}
+	var req api.SimpleRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+	}
+	if req.Name != "nano" {
+		t.Errorf("expected name is 'nano', but got '%s'", req.Name)
+	}
+}
+
+func TestInvokeCompressionWithoutEncoding(t *testing.T) {
+	t.Parallel()
+
+	var body bytes.Buffer
+	body.Write(compressedFrame(t, 0x01, []byte{}))
+	trOpt := withUnaryTransport(&unaryTransport{
+		t:          t,
+		expectedMD: metadata.Pairs("yuko", "aioi"),
+		r:          ioutil.NopCloser(&body),
+	})
+
+	client, err := DialContext(":50051", trOpt)
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
+	err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{}, &api.SimpleResponse{})
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) || se.GRPCStatus().Code() != codes.Internal {
+		t.Errorf("expected Internal error, but got '%v'", err)
+	}
+}
+
+// compressedFrame returns a frame which has b compressed by gzip.
+func compressedFrame(t *testing.T, flag byte, b []byte) []byte {
+	var buf bytes.Buffer
+	w := gz.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatalf("Write should not return an error, but got '%s'", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close should not return an error, but got '%s'", err)
+	}
+	var out bytes.Buffer
+	if err := frame.NewEncoder(&out).Encode(&frame.Frame{Flag: flag, Payload: buf.Bytes()}); err != nil {
+		t.Fatalf("Encode should not return an error, but got '%s'", err)
+	}
+	return out.Bytes()
+}
+
+// encodeFramesToText encodes each frame in b to base64 separately like gRPC-Web servers do.
+func encodeFramesToText(t *testing.T, b []byte) string {
+	var s strings.Builder
+	dec, enc := frame.NewDecoder(bytes.NewReader(b)), frame.NewTextEncoder(&s)
+	for {
+		f, err := dec.Decode()
+		if err == io.EOF {
+			return s.String()
+		}
+		if err != nil {
+			t.Fatalf("Decode should not return an error, but got '%s'", err)
+		}
+		if err := enc.Encode(f); err != nil {
+			t.Fatalf("Encode should not return an error, but got '%s'", err)
+		}
 	}
 }
 
+func withUnaryTransport(tr transport.UnaryTransport) DialOption {
+	return WithUnaryTransport(func(string, *transport.ConnectOptions) transport.UnaryTransport {
+		return tr
+	})
+}
+
+// closeRecorder records whether Close is called.
+type closeRecorder struct {
+	io.ReadCloser
+	closed atomic.Bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed.Store(true)
+	return r.ReadCloser.Close()
+}
+
 func TestServerStream(t *testing.T) {
+	t.Parallel()
+
 	header := http.Header{
 		"hakase": []string{"shinonome"},
 		"nano":   []string{"shinonome"},
@@ -232,32 +616,36 @@ func TestServerStream(t *testing.T) {
 	}
 
 	for name, c := range cases {
+		c := c
 		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
 			r, err := os.Open(filepath.Join("testdata", c.transportContentFileName))
 			if err != nil {
 				t.Fatalf("Open should not return an error, but got '%s'", err)
 			}
 
+			body := &closeRecorder{ReadCloser: r}
 			md := metadata.Pairs("yuko", "aioi")
 
-			injectUnaryTransport(t, &unaryTransport{
+			trOpt := withUnaryTransport(&unaryTransport{
 				t:          t,
 				expectedMD: md,
 				h:          c.transportHeader,
-				r:          r,
+				r:          body,
 			})
 
-			client, err := DialContext(":50051")
+			client, err := DialContext(":50051", trOpt)
 			if err != nil {
 				t.Fatalf("DialContext should not return an error, but got '%s'", err)
 			}
 
-			stm, err := client.NewServerStream(&grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+			stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
 			if err != nil {
 				t.Fatalf("should not return an error, but got '%s'", err)
 			}
 
-			ctx := metadata.NewOutgoingContext(context.Background(), md)
 			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
 				t.Fatalf("Send should not return an error, but got '%s'", err)
 			}
@@ -278,6 +666,9 @@ func TestServerStream(t *testing.T) {
 			}
 
 			stat := status.Convert(err)
+			if !body.closed.Load() {
+				t.Errorf("the response body should be closed after the stream is finished")
+			}
 
 			header, err := stm.Header()
 			if err != nil {
@@ -307,6 +698,7 @@ type clientStreamTransport struct {
 	expectedHeader http.Header
 
 	sentCloseSend bool
+	reqBodies     [][]byte
 
 	h, t http.Header
 	r    []io.ReadCloser
@@ -329,7 +721,12 @@ func (s *clientStreamTransport) Trailer() http.Header {
 	return s.t
 }
 
-func (s *clientStreamTransport) Send(context.Context, io.Reader) error {
+func (s *clientStreamTransport) Send(_ context.Context, body io.Reader) error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		s.tt.Fatalf("ReadAll should not return an error, but got '%s'", err)
+	}
+	s.reqBodies = append(s.reqBodies, b)
 	return nil
 }
 
@@ -351,7 +748,58 @@ func (s *clientStreamTransport) Close() error {
 	return nil
 }
 
+func TestTrailerBeforeReceived(t *testing.T) {
+	t.Parallel()
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	t.Run("server stream", func(t *testing.T) {
+		r, err := os.Open(filepath.Join("testdata", "server_stream_response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		client, err := DialContext(":50051", withUnaryTransport(&unaryTransport{t: t, expectedMD: md, r: r}))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		var res api.SimpleResponse
+		if err := stm.Receive(ctx, &res); err != nil {
+			t.Fatalf("Receive should not return an error, but got '%s'", err)
+		}
+		if md := stm.Trailer(); md != nil {
+			t.Errorf("Trailer should return nil, but got %v", md)
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		client, err := DialContext(":50051", withClientStreamTransport(&clientStreamTransport{
+			tt:             t,
+			expectedHeader: http.Header{"Content-Type": []string{"application/grpc-web+proto"}},
+		}))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		stm, err := client.NewClientStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		if md := stm.Trailer(); md != nil {
+			t.Errorf("Trailer should return nil, but got %v", md)
+		}
+	})
+}
+
 func TestClientStream(t *testing.T) {
+	t.Parallel()
+
 	header := http.Header{
 		"hakase": []string{"shinonome"},
 		"nano":   []string{"shinonome"},
@@ -423,7 +871,10 @@ func TestClientStream(t *testing.T) {
 	}
 
 	for name, c := range cases {
+		c := c
 		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
 			var rs []io.ReadCloser
 			for _, fname := range c.transportContentFileNames {
 				r, err := os.Open(filepath.Join("testdata", fname))
@@ -434,8 +885,9 @@ func TestClientStream(t *testing.T) {
 			}
 
 			h := make(http.Header)
+			h.Add("content-type", "application/grpc-web+proto")
 			h.Add("yuko", "aioi")
-			injectClientStreamTransport(t, &clientStreamTransport{
+			trOpt := withClientStreamTransport(&clientStreamTransport{
 				tt:             t,
 				expectedHeader: h,
 				h:              c.transportHeader,
@@ -443,17 +895,17 @@ func TestClientStream(t *testing.T) {
 				err:            c.transportErr,
 			})
 
-			client, err := DialContext(":50051")
+			client, err := DialContext(":50051", trOpt)
 			if err != nil {
 				t.Fatalf("DialContext should not return an error, but got '%s'", err)
 			}
 
-			stm, err := client.NewClientStream(&grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
+			stm, err := client.NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
 			if err != nil {
 				t.Fatalf("should not return an error, but got '%s'", err)
 			}
 
-			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
 			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
 				t.Fatalf("Send should not return an error, but got '%s'", err)
 			}
@@ -490,6 +942,8 @@ func TestClientStream(t *testing.T) {
 }
 
 func TestBidiStream(t *testing.T) {
+	t.Parallel()
+
 	header := http.Header{
 		"hakase": []string{"shinonome"},
 		"nano":   []string{"shinonome"},
@@ -568,7 +1022,10 @@ func TestBidiStream(t *testing.T) {
 	}
 
 	for name, c := range cases {
+		c := c
 		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
 			var rs []io.ReadCloser
 			for _, fname := range c.transportContentFileNames {
 				r, err := os.Open(filepath.Join("testdata", fname))
@@ -579,8 +1036,9 @@ func TestBidiStream(t *testing.T) {
 			}
 
 			h := make(http.Header)
+			h.Add("content-type", "application/grpc-web+proto")
 			h.Add("yuko", "aioi")
-			injectClientStreamTransport(t, &clientStreamTransport{
+			trOpt := withClientStreamTransport(&clientStreamTransport{
 				tt:             t,
 				expectedHeader: h,
 				h:              c.transportHeader,
@@ -588,17 +1046,17 @@ func TestBidiStream(t *testing.T) {
 				err:            c.transportErr,
 			})
 
-			client, err := DialContext(":50051")
+			client, err := DialContext(":50051", trOpt)
 			if err != nil {
 				t.Fatalf("DialContext should not return an error, but got '%s'", err)
 			}
 
-			stm, err := client.NewBidiStream(&grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
+			stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
 			if err != nil {
 				t.Fatalf("should not return an error, but got '%s'", err)
 			}
 
-			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
 			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
 				t.Fatalf("Send should not return an error, but got '%s'", err)
 			}
@@ -647,12 +1105,1382 @@ func TestBidiStream(t *testing.T) {
 	}
 }
 
-func injectClientStreamTransport(t *testing.T, tr transport.ClientStreamTransport) {
-	old := transport.NewClientStream
-	t.Cleanup(func() {
-		transport.NewClientStream = old
-	})
-	transport.NewClientStream = func(string, string) (transport.ClientStreamTransport, error) {
+func withClientStreamTransport(tr transport.ClientStreamTransport) DialOption {
+	return WithClientStreamTransport(func(context.Context, string, string, *transport.ConnectOptions) (transport.ClientStreamTransport, error) {
 		return tr, nil
+	})
+}
+
+func TestClientConnInterface(t *testing.T) {
+	t.Parallel()
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	newClientConn := func(t *testing.T, opt DialOption) grpc.ClientConnInterface {
+		client, err := DialContext(":50051", opt)
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		return client.ClientConnInterface()
+	}
+
+	expectedHeader := metadata.New(map[string]string{
+		"hakase": "shinonome",
+		"nano":   "shinonome",
+	})
+	expectedTrailer := metadata.New(map[string]string{
+		"trailer_key1": "trailer_val1",
+		"trailer_key2": "trailer_val2",
+	})
+
+	t.Run("unary", func(t *testing.T) {
+		r, err := os.Open(filepath.Join("testdata", "trailer_response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		trOpt := withUnaryTransport(&unaryTransport{
+			t:          t,
+			expectedMD: md,
+			h:          http.Header{"hakase": []string{"shinonome"}, "nano": []string{"shinonome"}},
+			r:          r,
+		})
+
+		var (
+			res             api.SimpleResponse
+			header, trailer metadata.MD
+		)
+		err = newClientConn(t, trOpt).Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, grpc.Header(&header), grpc.Trailer(&trailer))
+		if err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+		if diff := cmp.Diff(api.SimpleResponse{Message: "response"}, res); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(expectedHeader, header); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(expectedTrailer, trailer); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+	})
+
+	t.Run("content-subtype", func(t *testing.T) {
+		r, err := os.Open(filepath.Join("testdata", "response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		cc := newClientConn(t, withUnaryTransport(&unaryTransport{
+			t:                   t,
+			expectedMD:          md,
+			expectedContentType: "application/grpc-web+proto",
+			r:                   r,
+		}))
+
+		var res api.SimpleResponse
+		// Same as grpc/grpc-go, the content-subtype is case-insensitive.
+		if err := cc.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, grpc.CallContentSubtype("PROTO")); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+
+		err = cc.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, grpc.CallContentSubtype("JSON"))
+		if code := status.Code(err); code != codes.Internal {
+			t.Errorf("expected status code: %s, but got %s ('%v')", codes.Internal, code, err)
+		}
+		_, err = cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method", grpc.CallContentSubtype("JSON"))
+		if code := status.Code(err); code != codes.Internal {
+			t.Errorf("expected status code: %s, but got %s ('%v')", codes.Internal, code, err)
+		}
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		r, err := os.Open(filepath.Join("testdata", "server_stream_trailer_response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		trOpt := withUnaryTransport(&unaryTransport{
+			t:          t,
+			expectedMD: md,
+			h:          http.Header{"hakase": []string{"shinonome"}, "nano": []string{"shinonome"}},
+			r:          r,
+		})
+
+		var trailer metadata.MD
+		stm, err := newClientConn(t, trOpt).NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method", grpc.Trailer(&trailer))
+		if err != nil {
+			t.Fatalf("NewStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.SendMsg(&api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+		}
+		if err := stm.CloseSend(); err != nil {
+			t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+		}
+		var n int
+		for {
+			var res api.SimpleResponse
+			err := stm.RecvMsg(&res)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+			}
+			n++
+		}
+		if n != 3 {
+			t.Errorf("expected 3 messages, but got %d", n)
+		}
+		header, err := stm.Header()
+		if err != nil {
+			t.Fatalf("Header should not return an error, but got '%s'", err)
+		}
+		if diff := cmp.Diff(expectedHeader, header); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(expectedTrailer, stm.Trailer()); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(expectedTrailer, trailer); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		var rs []io.ReadCloser
+		for _, fname := range []string{"client_stream_trailer_response1.in", "client_stream_trailer_response2.in"} {
+			r, err := os.Open(filepath.Join("testdata", fname))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			rs = append(rs, r)
+		}
+		h := make(http.Header)
+		h.Add("content-type", "application/grpc-web+proto")
+		h.Add("yuko", "aioi")
+		trOpt := withClientStreamTransport(&clientStreamTransport{
+			tt:             t,
+			expectedHeader: h,
+			h:              http.Header{"hakase": []string{"shinonome"}, "nano": []string{"shinonome"}},
+			r:              rs,
+		})
+
+		stm, err := newClientConn(t, trOpt).NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewStream should not return an error, but got '%s'", err)
+		}
+		for _, name := range []string{"nano", "hakase"} {
+			if err := stm.SendMsg(&api.SimpleRequest{Name: name}); err != nil {
+				t.Fatalf("SendMsg should not return an error, but got '%s'", err)
+			}
+		}
+		if err := stm.CloseSend(); err != nil {
+			t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+		}
+		var res api.SimpleResponse
+		if err := stm.RecvMsg(&res); err != nil {
+			t.Fatalf("RecvMsg should not return an error, but got '%s'", err)
+		}
+		if diff := cmp.Diff(api.SimpleResponse{Message: "you sent requests 2 times (hakase, nano)."}, res); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(expectedTrailer, stm.Trailer()); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+	})
+}
+
+// newCountingServer starts a gRPC-Web server which responds the content of testdata/response.in.
+// It returns a function that reports the number of accepted connections, which is the number of TLS handshakes.
+func newCountingServer(tb testing.TB) (*httptest.Server, DialOption, func() int64) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		tb.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write(b)
+	}))
+	var n atomic.Int64
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			n.Inc()
+		}
+	}
+	srv.StartTLS()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	creds := credentials.NewTLS(&tls.Config{
+		RootCAs:    pool,
+		ServerName: "example.com",
+		NextProtos: []string{"http/1.1"},
+	})
+	return srv, WithTransportCredentials(creds), n.Load
+}
+
+func TestClientConnClose(t *testing.T) {
+	t.Parallel()
+
+	srv, credsOpt, handshakes := newCountingServer(t)
+	defer srv.Close()
+
+	client, err := DialContext(srv.Listener.Addr().String(), credsOpt)
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	for i := 0; i < 3; i++ {
+		var res api.SimpleResponse
+		if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+	}
+	if n := handshakes(); n != 1 {
+		t.Errorf("expected 1 handshake, but got %d", n)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close should not return an error, but got '%s'", err)
+	}
+	var res api.SimpleResponse
+	err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+	if code := status.Code(err); code != codes.Canceled {
+		t.Errorf("expected status code: %s, but got %s", codes.Canceled, code)
+	}
+	if _, err := client.NewServerStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/service/Method"); status.Code(err) != codes.Canceled {
+		t.Errorf("expected status code: %s, but got %s", codes.Canceled, status.Code(err))
+	}
+	if err := client.Close(); err == nil {
+		t.Errorf("second Close should return an error, but got nil")
+	}
+}
+
+// newStalledServer returns a server which never finishes responses.
+// HTTP responses consist of the response header and msg, and WebSocket connections are read until they are closed.
+// The returned channel receives a value when a request arrives.
+func newStalledServer(t *testing.T, msg []byte) (*httptest.Server, <-chan struct{}) {
+	arrived := make(chan struct{}, 16)
+	notify := func() {
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			conn := wstest.Upgrade(t, w, r)
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			notify()
+			wstest.Drain(conn)
+			return
+		}
+		// The connection closed by the client is detected after the body is read.
+		io.Copy(ioutil.Discard, r.Body)
+		notify()
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write(msg)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	return srv, arrived
+}
+
+func TestClientConnCloseInFlight(t *testing.T) {
+	t.Parallel()
+
+	srv, arrived := newStalledServer(t, nil)
+	defer srv.Close()
+
+	client, err := DialContext(srv.Listener.Addr().String(), WithInsecure())
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+
+	ctx := context.Background()
+	var res api.SimpleResponse
+	calls := map[string]func() error{
+		"unary": func() error {
+			return client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+		},
+		"bidi stream": func() error {
+			stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/service/Method")
+			if err != nil {
+				return err
+			}
+			return stm.Receive(ctx, &res)
+		},
+	}
+	errs := make(map[string]chan error)
+	for name, call := range calls {
+		call := call
+		errCh := make(chan error, 1)
+		errs[name] = errCh
+		go func() { errCh <- call() }()
+	}
+
+	// Close after all requests have arrived at the server.
+	for range calls {
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the requests should arrive at the server")
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close should not return an error, but got '%s'", err)
+	}
+	for name, errCh := range errs {
+		select {
+		case err := <-errCh:
+			if code := status.Code(err); code != codes.Canceled {
+				t.Errorf("%s: expected status code: %s, but got %s ('%v')", name, codes.Canceled, code, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: the RPC in flight should be canceled by Close", name)
+		}
+	}
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	srv, credsOpt, handshakes := newCountingServer(b)
+	defer srv.Close()
+
+	dial := func(b *testing.B) *ClientConn {
+		client, err := DialContext(srv.Listener.Addr().String(), credsOpt)
+		if err != nil {
+			b.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		return client
+	}
+	invoke := func(b *testing.B, client *ClientConn) {
+		var res api.SimpleResponse
+		if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+			b.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+	}
+
+	b.Run("shared ClientConn", func(b *testing.B) {
+		client := dial(b)
+		defer client.Close()
+		start := handshakes()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			invoke(b, client)
+		}
+		b.ReportMetric(float64(handshakes()-start)/float64(b.N), "handshakes/op")
+	})
+
+	// It behaves like releasing connections after every call.
+	b.Run("ClientConn per call", func(b *testing.B) {
+		start := handshakes()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			client := dial(b)
+			invoke(b, client)
+			client.Close()
+		}
+		b.ReportMetric(float64(handshakes()-start)/float64(b.N), "handshakes/op")
+	})
+}
+
+func TestContextCancellation(t *testing.T) {
+	t.Parallel()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	// The server responds only the first message and never finishes the response.
+	msg := b[:frame.HeaderLen+binary.BigEndian.Uint32(b[1:frame.HeaderLen])]
+	srv, _ := newStalledServer(t, msg)
+	defer srv.Close()
+
+	client, err := DialContext(srv.Listener.Addr().String(), WithInsecure())
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	defer client.Close()
+
+	cases := map[string]struct {
+		newContext   func() (context.Context, context.CancelFunc)
+		expectedCode codes.Code
+	}{
+		"canceled": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expectedCode: codes.Canceled,
+		},
+		"deadline exceeded": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			expectedCode: codes.DeadlineExceeded,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run("unary/"+name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			var res api.SimpleResponse
+			err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+			if _, ok := err.(interface{ GRPCStatus() *status.Status }); !ok {
+				t.Fatalf("Invoke should return a status error, but got '%v'", err)
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s", c.expectedCode, code)
+			}
+		})
+
+		t.Run("server stream/"+name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+			}
+			if err := stm.Send(context.Background(), &api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			var res api.SimpleResponse
+			if err := stm.Receive(ctx, &res); err != nil {
+				t.Fatalf("Receive should not return an error, but got '%s'", err)
+			}
+			err = stm.Receive(ctx, &res)
+			if _, ok := err.(interface{ GRPCStatus() *status.Status }); !ok {
+				t.Fatalf("Receive should return a status error, but got '%v'", err)
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s", c.expectedCode, code)
+			}
+		})
+
+		t.Run("bidi stream/"+name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewBidiStream should not return an error, but got '%s'", err)
+			}
+			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			// The server never responds.
+			var res api.SimpleResponse
+			err = stm.Receive(ctx, &res)
+			if _, ok := err.(interface{ GRPCStatus() *status.Status }); !ok {
+				t.Fatalf("Receive should return a status error, but got '%v'", err)
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s", c.expectedCode, code)
+			}
+		})
+
+		t.Run("bidi stream bound context/"+name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewBidiStream should not return an error, but got '%s'", err)
+			}
+			// The context of the stream tears down it even if the context of the call is not done.
+			var res api.SimpleResponse
+			err = stm.Receive(context.Background(), &res)
+			if _, ok := err.(interface{ GRPCStatus() *status.Status }); !ok {
+				t.Fatalf("Receive should return a status error, but got '%v'", err)
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s", c.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestStreamContextCancellationWithBlockedSend(t *testing.T) {
+	t.Parallel()
+
+	// The server never reads messages, so writes of the client are blocked once the buffers are full.
+	stop := make(chan struct{})
+	defer close(stop)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		<-stop
+	}))
+	defer srv.Close()
+
+	client, err := DialContext(srv.Listener.Addr().String(), WithInsecure())
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/service/Method")
+	if err != nil {
+		t.Fatalf("NewBidiStream should not return an error, but got '%s'", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		req := &api.SimpleRequest{Name: strings.Repeat("a", 1<<20)}
+		for {
+			// The context of the call is never done.
+			if err := stm.Send(context.Background(), req); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if code := status.Code(err); code != codes.Canceled {
+			t.Errorf("expected status code: %s, but got %s ('%v')", codes.Canceled, code, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the blocked Send should return after the context of the stream is canceled")
+	}
+}
+
+func TestEncodeTimeout(t *testing.T) {
+	t.Parallel()
+
+	cases := map[time.Duration]string{
+		0:                           "0n",
+		-time.Second:                "0n",
+		time.Nanosecond:             "1n",
+		99999999 * time.Nanosecond:  "99999999n",
+		100000000 * time.Nanosecond: "100000u",
+		1500 * time.Microsecond:     "1500000n",
+		time.Second:                 "1000000u",
+		100 * time.Second:           "100000m",
+		time.Hour:                   "3600000m",
+		1000 * time.Hour:            "3600000S",
+		200000 * time.Hour:          "12000000M",
+	}
+	for in, expected := range cases {
+		if got := encodeTimeout(in); got != expected {
+			t.Errorf("encodeTimeout(%s): expected '%s', but got '%s'", in, expected, got)
+		}
+	}
+}
+
+// requestHeaderRecorder records the request header sent by the first Send of a client stream.
+type requestHeaderRecorder struct {
+	transport.ClientStreamTransport
+
+	h, sent http.Header
+}
+
+func (r *requestHeaderRecorder) SetRequestHeader(h http.Header) { r.h = h }
+func (r *requestHeaderRecorder) Close() error                   { return nil }
+
+func (r *requestHeaderRecorder) Send(context.Context, io.Reader) error {
+	if r.sent == nil {
+		r.sent = r.h.Clone()
+	}
+	return nil
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	t.Parallel()
+
+	const timeout = time.Minute
+
+	// assertTimeout asserts that grpc-timeout is in (0, max].
+	assertTimeout := func(t *testing.T, h http.Header, max time.Duration) {
+		t.Helper()
+		v := h.Get("grpc-timeout")
+		if !strings.HasSuffix(v, "u") {
+			t.Fatalf("grpc-timeout should be in microseconds, but got '%s'", v)
+		}
+		d, err := time.ParseDuration(strings.TrimSuffix(v, "u") + "us")
+		if err != nil {
+			t.Fatalf("ParseDuration should not return an error, but got '%s'", err)
+		}
+		if d <= 0 || d > max {
+			t.Errorf("grpc-timeout should be in (0, %s], but got %s", max, d)
+		}
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+
+		r, err := os.Open(filepath.Join("testdata", "response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		tr := &unaryTransport{t: t, expectedMD: metadata.MD{}, r: r}
+		client, err := DialContext(":50051", withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), metadata.MD{}), timeout)
+		defer cancel()
+		var res api.SimpleResponse
+		if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+		assertTimeout(t, tr.reqHeader, timeout)
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &unaryTransport{t: t, expectedMD: metadata.MD{}, r: ioutil.NopCloser(strings.NewReader(""))}
+		client, err := DialContext(":50051", withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), metadata.MD{}), timeout)
+		defer cancel()
+		stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		// The timeout is computed when the request is sent, not when the stream is created.
+		const delay = 100 * time.Millisecond
+		time.Sleep(delay)
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		assertTimeout(t, tr.reqHeader, timeout-delay)
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &requestHeaderRecorder{}
+		client, err := DialContext(":50051", withClientStreamTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		stm, err := client.NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		// The timeout is computed when the header frame is sent by the first Send.
+		const delay = 100 * time.Millisecond
+		time.Sleep(delay)
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		assertTimeout(t, tr.sent, timeout-delay)
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &unaryTransport{t: t, err: errors.New("Send should not be called")}
+		client, err := DialContext(":50051", withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		var res api.SimpleResponse
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+		if code := status.Code(err); code != codes.DeadlineExceeded {
+			t.Errorf("expected status code: %s, but got %s", codes.DeadlineExceeded, code)
+		}
+	})
+}
+
+func TestShortReads(t *testing.T) {
+	t.Parallel()
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	}
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	for name, newReader := range readers {
+		newReader := newReader
+		t.Run("unary/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "trailer_response.in"))
+			if err != nil {
+				t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+			}
+			trOpt := withUnaryTransport(&unaryTransport{
+				t:          t,
+				expectedMD: md,
+				r:          ioutil.NopCloser(newReader(bytes.NewReader(b))),
+			})
+			client, err := DialContext(":50051", trOpt)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			var res api.SimpleResponse
+			if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+				t.Fatalf("Invoke should not return an error, but got '%s'", err)
+			}
+			if diff := cmp.Diff(api.SimpleResponse{Message: "response"}, res); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+
+		t.Run("server stream/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "server_stream_response.in"))
+			if err != nil {
+				t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+			}
+			trOpt := withUnaryTransport(&unaryTransport{
+				t:          t,
+				expectedMD: md,
+				r:          ioutil.NopCloser(newReader(bytes.NewReader(b))),
+			})
+			client, err := DialContext(":50051", trOpt)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+			}
+			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			var n int
+			for {
+				var res api.SimpleResponse
+				err := stm.Receive(ctx, &res)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Receive should not return an error, but got '%s'", err)
+				}
+				n++
+			}
+			if n == 0 {
+				t.Errorf("messages should be received")
+			}
+		})
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	newBody := func(t *testing.T, msg string, compressed bool) []byte {
+		b, err := proto.Marshal(&api.SimpleResponse{Message: msg})
+		if err != nil {
+			t.Fatalf("Marshal should not return an error, but got '%s'", err)
+		}
+		var buf bytes.Buffer
+		if compressed {
+			buf.Write(compressedFrame(t, frame.FlagCompressed, b))
+		} else if err := frame.NewEncoder(&buf).EncodeMessage(b, false); err != nil {
+			t.Fatalf("EncodeMessage should not return an error, but got '%s'", err)
+		}
+		if err := frame.NewEncoder(&buf).EncodeTrailer(status.New(codes.OK, ""), metadata.Pairs("trailer_key1", "trailer_val1")); err != nil {
+			t.Fatalf("EncodeTrailer should not return an error, but got '%s'", err)
+		}
+		return buf.Bytes()
+	}
+
+	cases := map[string]struct {
+		msg          string
+		compressed   bool
+		dialOpts     []DialOption
+		callOpts     []CallOption
+		serverStream bool
+		wantCode     codes.Code
+	}{
+		"received message is larger than the call option": {
+			msg:      "hello, ktr",
+			callOpts: []CallOption{MaxCallRecvMsgSize(5)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"received message is larger than the dial option": {
+			msg:      "hello, ktr",
+			dialOpts: []DialOption{WithDefaultCallOptions(MaxCallRecvMsgSize(5))},
+			wantCode: codes.ResourceExhausted,
+		},
+		"call option overrides the dial option": {
+			msg:      "hello, ktr",
+			dialOpts: []DialOption{WithDefaultCallOptions(MaxCallRecvMsgSize(5))},
+			callOpts: []CallOption{MaxCallRecvMsgSize(100)},
+			wantCode: codes.OK,
+		},
+		"decompressed message is larger than max": {
+			msg:        strings.Repeat("a", 1000),
+			compressed: true,
+			callOpts:   []CallOption{MaxCallRecvMsgSize(100)},
+			wantCode:   codes.ResourceExhausted,
+		},
+		"sent message is larger than max": {
+			msg:      "hello, ktr",
+			callOpts: []CallOption{MaxCallSendMsgSize(1)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"zero max message size": {
+			msg:      "hello, ktr",
+			callOpts: []CallOption{MaxCallRecvMsgSize(0)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"negative max message size": {
+			msg:      "hello, ktr",
+			callOpts: []CallOption{MaxCallRecvMsgSize(-1)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"trailer is larger than the header list size": {
+			msg:      "hello, ktr",
+			dialOpts: []DialOption{WithMaxHeaderListSize(40)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"zero header list size": {
+			msg:      "hello, ktr",
+			dialOpts: []DialOption{WithMaxHeaderListSize(0)},
+			wantCode: codes.ResourceExhausted,
+		},
+		"server stream: received message is larger than max": {
+			msg:          "hello, ktr",
+			callOpts:     []CallOption{MaxCallRecvMsgSize(5)},
+			serverStream: true,
+			wantCode:     codes.ResourceExhausted,
+		},
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          http.Header{"grpc-encoding": []string{"gzip"}},
+				r:          ioutil.NopCloser(bytes.NewReader(newBody(t, c.msg, c.compressed))),
+			}
+			client, err := DialContext(":50051", append(c.dialOpts, withUnaryTransport(tr))...)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			var res api.SimpleResponse
+			if c.serverStream {
+				var stm ServerStream
+				stm, err = client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method", c.callOpts...)
+				if err != nil {
+					t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+				}
+				if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+					t.Fatalf("Send should not return an error, but got '%s'", err)
+				}
+				err = stm.Receive(ctx, &res)
+			} else {
+				err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, c.callOpts...)
+			}
+			if c.wantCode == codes.OK {
+				if err != nil {
+					t.Fatalf("should not return an error, but got '%s'", err)
+				}
+				return
+			}
+			var se interface{ GRPCStatus() *status.Status }
+			if !errors.As(err, &se) || se.GRPCStatus().Code() != c.wantCode {
+				t.Errorf("expected %s error, but got '%v'", c.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestTrailersOnly(t *testing.T) {
+	t.Parallel()
+
+	detailed, err := status.New(codes.InvalidArgument, "invalid argument").WithDetails(&api.SimpleResponse{Message: "detail"})
+	if err != nil {
+		t.Fatalf("WithDetails should not return an error, but got '%s'", err)
+	}
+	b, err := proto.Marshal(detailed.Proto())
+	if err != nil {
+		t.Fatalf("Marshal should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]struct {
+		header         http.Header
+		expectedStatus *status.Status
+	}{
+		"error": {
+			header: http.Header{
+				"Grpc-Status":  []string{"5"},
+				"Grpc-Message": []string{"not found"},
+				"Trailer_key1": []string{"trailer_val1"},
+			},
+			expectedStatus: status.New(codes.NotFound, "not found"),
+		},
+		"percent-encoded grpc-message": {
+			header: http.Header{
+				"Grpc-Status":  []string{"5"},
+				"Grpc-Message": []string{"not found: 100%25"},
+			},
+			expectedStatus: status.New(codes.NotFound, "not found: 100%"),
+		},
+		"without grpc-message": {
+			header:         http.Header{"Grpc-Status": []string{"14"}},
+			expectedStatus: status.New(codes.Unavailable, ""),
+		},
+		"with grpc-status-details-bin": {
+			header: http.Header{
+				"Grpc-Status":             []string{"3"},
+				"Grpc-Message":            []string{"invalid argument"},
+				"Grpc-Status-Details-Bin": []string{base64.RawStdEncoding.EncodeToString(b)},
+			},
+			expectedStatus: detailed,
+		},
+		"without grpc-status": {
+			header:         http.Header{},
+			expectedStatus: status.New(codes.Unknown, "response closed without grpc-status (headers only)"),
+		},
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	for name, c := range cases {
+		c := c
+		t.Run("unary/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			trOpt := withUnaryTransport(&unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          c.header,
+				r:          ioutil.NopCloser(bytes.NewReader(nil)),
+			})
+			client, err := DialContext(":50051", trOpt)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			var trailer metadata.MD
+			err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{}, Trailer(&trailer))
+			var se interface{ GRPCStatus() *status.Status }
+			if !errors.As(err, &se) {
+				t.Fatalf("expected a status error, but got '%v'", err)
+			}
+			if diff := cmp.Diff(c.expectedStatus.Proto(), se.GRPCStatus().Proto(), protocmp.Transform()); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+			if diff := cmp.Diff(toMetadata(c.header), trailer); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+
+		t.Run("server stream/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			trOpt := withUnaryTransport(&unaryTransport{
+				t:          t,
+				expectedMD: md,
+				h:          c.header,
+				r:          ioutil.NopCloser(bytes.NewReader(nil)),
+			})
+			client, err := DialContext(":50051", trOpt)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+			}
+			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			err = stm.Receive(ctx, &api.SimpleResponse{})
+			var se interface{ GRPCStatus() *status.Status }
+			if !errors.As(err, &se) {
+				t.Fatalf("expected a status error, but got '%v'", err)
+			}
+			if diff := cmp.Diff(c.expectedStatus.Proto(), se.GRPCStatus().Proto(), protocmp.Transform()); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+			if diff := cmp.Diff(toMetadata(c.header), stm.Trailer()); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("server stream/ok", func(t *testing.T) {
+		t.Parallel()
+
+		trOpt := withUnaryTransport(&unaryTransport{
+			t:          t,
+			expectedMD: md,
+			h:          http.Header{"Grpc-Status": []string{"0"}},
+			r:          ioutil.NopCloser(bytes.NewReader(nil)),
+		})
+		client, err := DialContext(":50051", trOpt)
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		if err := stm.Receive(ctx, &api.SimpleResponse{}); err != io.EOF {
+			t.Errorf("expected error is io.EOF, but got '%v'", err)
+		}
+	})
+}
+
+func TestInvokeHTTPError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client, err := DialContext(srv.Listener.Addr().String(), WithInsecure())
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	defer client.Close()
+
+	err = client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) || se.GRPCStatus().Code() != codes.Unavailable {
+		t.Fatalf("expected Unavailable error, but got '%v'", err)
+	}
+	if msg := se.GRPCStatus().Message(); !strings.Contains(msg, "503") || !strings.Contains(msg, "no healthy upstream") {
+		t.Errorf("the message should contain the HTTP status and the body, but got '%s'", msg)
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	t.Parallel()
+
+	// Reserve a port and release it so that dialing to it fails.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen should not return an error, but got '%s'", err)
 	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	t.Run("unary dial failure", func(t *testing.T) {
+		client, err := DialContext(closedAddr, WithInsecure())
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		defer client.Close()
+
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+		if code := status.Code(err); code != codes.Unavailable {
+			t.Errorf("expected code is %s, but got %s ('%v')", codes.Unavailable, code, err)
+		}
+		var operr *net.OpError
+		if !errors.As(err, &operr) {
+			t.Errorf("the cause should be reachable, but got '%v'", err)
+		}
+	})
+
+	t.Run("bidi stream dial failure", func(t *testing.T) {
+		client, err := DialContext(closedAddr, WithInsecure())
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		defer client.Close()
+
+		_, err = client.NewBidiStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+		if code := status.Code(err); code != codes.Unavailable {
+			t.Errorf("expected code is %s, but got %s ('%v')", codes.Unavailable, code, err)
+		}
+		var operr *net.OpError
+		if !errors.As(err, &operr) {
+			t.Errorf("the cause should be reachable, but got '%v'", err)
+		}
+	})
+
+	t.Run("malformed frame", func(t *testing.T) {
+		trOpt := withUnaryTransport(&unaryTransport{
+			t:          t,
+			expectedMD: md,
+			r:          ioutil.NopCloser(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x05, 0x01})),
+		})
+		client, err := DialContext(":50051", trOpt)
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+		if code := status.Code(err); code != codes.Internal {
+			t.Errorf("expected code is %s, but got %s ('%v')", codes.Internal, code, err)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("the cause should be io.ErrUnexpectedEOF, but got '%v'", err)
+		}
+	})
+
+	t.Run("status from the transport", func(t *testing.T) {
+		terr := &transport.Error{Code: codes.Unavailable, Msg: "failed to send the API", Err: io.ErrClosedPipe}
+		trOpt := withUnaryTransport(&unaryTransport{
+			t:          t,
+			expectedMD: md,
+			err:        terr,
+		})
+		client, err := DialContext(":50051", trOpt)
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &api.SimpleResponse{})
+		if code := status.Code(err); code != codes.Unavailable {
+			t.Errorf("expected code is %s, but got %s ('%v')", codes.Unavailable, code, err)
+		}
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("the cause should be io.ErrClosedPipe, but got '%v'", err)
+		}
+	})
+}
+
+func TestBinaryMetadata(t *testing.T) {
+	t.Parallel()
+
+	md := metadata.Pairs("trace-bin", "\x00\x01\x02", "key", "val")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+
+		var body bytes.Buffer
+		enc := frame.NewEncoder(&body)
+		if err := enc.EncodeMessage(nil, false); err != nil {
+			t.Fatalf("EncodeMessage should not return an error, but got '%s'", err)
+		}
+		if err := enc.EncodeTrailer(status.New(codes.OK, ""), metadata.Pairs("trailer-bin", "\xff\xfe")); err != nil {
+			t.Fatalf("EncodeTrailer should not return an error, but got '%s'", err)
+		}
+		tr := &unaryTransport{
+			t:          t,
+			expectedMD: md,
+			h: http.Header{
+				"Header-Bin": []string{"AAE", "AgM="},
+				"Joined-Bin": []string{"AAE, AgM"},
+				// Values which cannot be decoded are kept as they are.
+				"Invalid-Bin": []string{"AAE, !!"},
+			},
+			r: ioutil.NopCloser(&body),
+		}
+		client, err := DialContext(":50051", withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		var header, trailer metadata.MD
+		if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{}, &api.SimpleResponse{}, Header(&header), Trailer(&trailer)); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+		if v := tr.reqHeader.Get("trace-bin"); v != "AAEC" {
+			t.Errorf("expected trace-bin is 'AAEC', but got '%s'", v)
+		}
+		if v := tr.reqHeader.Get("key"); v != "val" {
+			t.Errorf("expected key is 'val', but got '%s'", v)
+		}
+		expectedHeader := metadata.MD{
+			"header-bin":  []string{"\x00\x01", "\x02\x03"},
+			"joined-bin":  []string{"\x00\x01", "\x02\x03"},
+			"invalid-bin": []string{"AAE, !!"},
+		}
+		if diff := cmp.Diff(expectedHeader, header); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if diff := cmp.Diff(metadata.Pairs("trailer-bin", "\xff\xfe"), trailer); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &requestHeaderRecorder{}
+		client, err := DialContext(":50051", withClientStreamTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		if _, err := client.NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method"); err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		if v := tr.h.Get("trace-bin"); v != "AAEC" {
+			t.Errorf("expected trace-bin is 'AAEC', but got '%s'", v)
+		}
+	})
+}
+
+type perRPCCredentials struct {
+	md              map[string]string
+	requireSecurity bool
+	err             error
+
+	uri []string
+}
+
+func (c *perRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	c.uri = uri
+	return c.md, c.err
+}
+
+func (c *perRPCCredentials) RequireTransportSecurity() bool {
+	return c.requireSecurity
+}
+
+func TestPerRPCCredentials(t *testing.T) {
+	t.Parallel()
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+
+		r, err := os.Open(filepath.Join("testdata", "response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		tr := &unaryTransport{t: t, expectedMD: md, r: r}
+		dialCreds := &perRPCCredentials{md: map[string]string{"Authorization": "Bearer dial", "token-bin": "\x00\x01"}, requireSecurity: true}
+		client, err := DialContext("example.com:443", withUnaryTransport(tr), WithPerRPCCredentials(dialCreds))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+
+		callCreds := &perRPCCredentials{md: map[string]string{"x-call": "call"}}
+		var res api.SimpleResponse
+		if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res, PerRPCCredentials(callCreds)); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+		if diff := cmp.Diff([]string{"https://example.com/service"}, dialCreds.uri); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		expected := map[string]string{
+			"authorization": "Bearer dial",
+			"token-bin":     "AAE",
+			"x-call":        "call",
+			"yuko":          "aioi",
+		}
+		for k, v := range expected {
+			if got := tr.reqHeader.Get(k); got != v {
+				t.Errorf("expected %s is '%s', but got '%s'", k, v, got)
+			}
+		}
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &unaryTransport{t: t, expectedMD: md, r: ioutil.NopCloser(strings.NewReader(""))}
+		client, err := DialContext(":50051", withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		creds := &perRPCCredentials{md: map[string]string{"authorization": "Bearer call"}}
+		stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method", PerRPCCredentials(creds))
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		if v := tr.reqHeader.Get("authorization"); v != "Bearer call" {
+			t.Errorf("expected authorization is 'Bearer call', but got '%s'", v)
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &requestHeaderRecorder{}
+		creds := &perRPCCredentials{md: map[string]string{"authorization": "Bearer dial"}}
+		client, err := DialContext(":50051", withClientStreamTransport(tr), WithPerRPCCredentials(creds))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		if _, err := client.NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method"); err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		if v := tr.h.Get("authorization"); v != "Bearer dial" {
+			t.Errorf("expected authorization is 'Bearer dial', but got '%s'", v)
+		}
+	})
+
+	t.Run("dial creds require transport security", func(t *testing.T) {
+		t.Parallel()
+
+		creds := &perRPCCredentials{requireSecurity: true}
+		if _, err := DialContext(":50051", WithInsecure(), WithPerRPCCredentials(creds)); err == nil {
+			t.Errorf("DialContext should return an error, but got nil")
+		}
+	})
+
+	t.Run("call creds require transport security", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &unaryTransport{t: t, err: errors.New("Send should not be called")}
+		client, err := DialContext(":50051", WithInsecure(), withUnaryTransport(tr))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		creds := &perRPCCredentials{requireSecurity: true}
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{}, &api.SimpleResponse{}, PerRPCCredentials(creds))
+		if code := status.Code(err); code != codes.Unauthenticated {
+			t.Errorf("expected status code: %s, but got %s", codes.Unauthenticated, code)
+		}
+	})
+
+	t.Run("GetRequestMetadata fails", func(t *testing.T) {
+		t.Parallel()
+
+		tr := &unaryTransport{t: t, err: errors.New("Send should not be called")}
+		creds := &perRPCCredentials{err: errors.New("token expired")}
+		client, err := DialContext(":50051", withUnaryTransport(tr), WithPerRPCCredentials(creds))
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{}, &api.SimpleResponse{})
+		if code := status.Code(err); code != codes.Unauthenticated {
+			t.Errorf("expected status code: %s, but got %s", codes.Unauthenticated, code)
+		}
+	})
 }
@@ -0,0 +1,112 @@
+package grpcweb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryInvoker is called by UnaryClientInterceptor to complete RPCs.
+type UnaryInvoker func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, opts ...CallOption) error
+
+// UnaryClientInterceptor intercepts the execution of a unary RPC on the client.
+// It is the equivalent of grpc.UnaryClientInterceptor.
+// invoker is the handler to complete the RPC and it is the responsibility of the interceptor to call it.
+type UnaryClientInterceptor func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error
+
+// chainUnaryClientInterceptors chains all unary client interceptors into one.
+// The first interceptor will be the outer most, while the last interceptor will be the inner most wrapper around the real call.
+func chainUnaryClientInterceptors(opts *dialOptions) {
+	interceptors := opts.chainUnaryInts
+	if opts.unaryInt != nil {
+		interceptors = append([]UnaryClientInterceptor{opts.unaryInt}, interceptors...)
+	}
+	switch len(interceptors) {
+	case 0:
+		opts.unaryInt = nil
+	case 1:
+		opts.unaryInt = interceptors[0]
+	default:
+		opts.unaryInt = func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, invoker UnaryInvoker, callOpts ...CallOption) error {
+			return interceptors[0](ctx, method, req, reply, cc, getChainUnaryInvoker(interceptors, 0, invoker), callOpts...)
+		}
+	}
+}
+
+// getChainUnaryInvoker recursively generates the chained unary invoker.
+func getChainUnaryInvoker(interceptors []UnaryClientInterceptor, curr int, finalInvoker UnaryInvoker) UnaryInvoker {
+	if curr == len(interceptors)-1 {
+		return finalInvoker
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, opts ...CallOption) error {
+		return interceptors[curr+1](ctx, method, req, reply, cc, getChainUnaryInvoker(interceptors, curr+1, finalInvoker), opts...)
+	}
+}
+
+// Stream is the common interface of ClientStream, ServerStream and BidiStream.
+// StreamClientInterceptor can decorate streams by wrapping it.
+//
+// For client streams, CloseAndReceive is performed as CloseSend followed by Receive.
+// For server streams, CloseSend does nothing.
+type Stream interface {
+	// Header returns the header metadata from the server, if there is any.
+	// It blocks if the metadata is not ready to read.
+	Header() (metadata.MD, error)
+	// Trailer returns the trailer metadata from the server, if there is any.
+	// It must only be called after stream.Receive has returned a non-nil error (including io.EOF).
+	Trailer() metadata.MD
+	Send(ctx context.Context, req interface{}) error
+	Receive(ctx context.Context, res interface{}) error
+	CloseSend() error
+}
+
+// Streamer is called by StreamClientInterceptor to create a Stream.
+// ctx is bound to the stream and governs the whole lifetime of it.
+type Streamer func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, opts ...CallOption) (Stream, error)
+
+// StreamClientInterceptor intercepts the creation of a client, server or bidi stream.
+// It is the equivalent of grpc.StreamClientInterceptor.
+// The kind of the stream can be determined by desc.
+// streamer is the handler to create a Stream and it is the responsibility of the interceptor to call it.
+type StreamClientInterceptor func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error)
+
+// chainStreamClientInterceptors chains all stream client interceptors into one.
+func chainStreamClientInterceptors(opts *dialOptions) {
+	interceptors := opts.chainStreamInts
+	if opts.streamInt != nil {
+		interceptors = append([]StreamClientInterceptor{opts.streamInt}, interceptors...)
+	}
+	switch len(interceptors) {
+	case 0:
+		opts.streamInt = nil
+	case 1:
+		opts.streamInt = interceptors[0]
+	default:
+		opts.streamInt = func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, callOpts ...CallOption) (Stream, error) {
+			return interceptors[0](ctx, desc, cc, method, getChainStreamer(interceptors, 0, streamer), callOpts...)
+		}
+	}
+}
+
+// getChainStreamer recursively generates the chained client stream constructor.
+func getChainStreamer(interceptors []StreamClientInterceptor, curr int, finalStreamer Streamer) Streamer {
+	if curr == len(interceptors)-1 {
+		return finalStreamer
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, opts ...CallOption) (Stream, error) {
+		return interceptors[curr+1](ctx, desc, cc, method, getChainStreamer(interceptors, curr+1, finalStreamer), opts...)
+	}
+}
+
+// interceptedClientStream converts Stream returned from StreamClientInterceptor to ClientStream.
+type interceptedClientStream struct {
+	Stream
+}
+
+func (s *interceptedClientStream) CloseAndReceive(ctx context.Context, res interface{}) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	return s.Receive(ctx, res)
+}
@@ -0,0 +1,239 @@
+package grpcweb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/ktr0731/grpc-test/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryInterceptor(t *testing.T) {
+	t.Parallel()
+
+	r, err := os.Open(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("Open should not return an error, but got '%s'", err)
+	}
+	tr := &unaryTransport{
+		t:          t,
+		expectedMD: metadata.Pairs("yuko", "aioi", "authorization", "bearer token"),
+		h:          http.Header{"hakase": []string{"shinonome"}},
+		r:          r,
+	}
+	trOpt := withUnaryTransport(tr)
+
+	var calls []string
+	newInterceptor := func(name string) UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error {
+			calls = append(calls, name)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	var header metadata.MD
+	auth := func(ctx context.Context, method string, req, reply interface{}, cc *ClientConn, invoker UnaryInvoker, opts ...CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer token")
+		req = &api.SimpleRequest{Name: "hakase"}
+		return invoker(ctx, method, req, reply, cc, append(opts, Header(&header))...)
+	}
+
+	client, err := DialContext(
+		":50051",
+		trOpt,
+		WithUnaryInterceptor(newInterceptor("first")),
+		WithChainUnaryInterceptor(newInterceptor("second"), auth, newInterceptor("third")),
+	)
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+
+	var res api.SimpleResponse
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("yuko", "aioi"))
+	if err := client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+		t.Fatalf("Invoke should not return an error, but got '%s'", err)
+	}
+
+	if diff := cmp.Diff([]string{"first", "second", "third"}, calls); diff != "" {
+		t.Errorf("-want, +got\n%s", diff)
+	}
+	if diff := cmp.Diff(metadata.Pairs("hakase", "shinonome"), header); diff != "" {
+		t.Errorf("the call option added by the interceptor should be applied: -want, +got\n%s", diff)
+	}
+	var req api.SimpleRequest
+	if err := proto.Unmarshal(tr.reqBody[5:], &req); err != nil {
+		t.Fatalf("Unmarshal should not return an error, but got '%s'", err)
+	}
+	if req.Name != "hakase" {
+		t.Errorf("expected the request replaced by the interceptor, but got '%s'", req.Name)
+	}
+}
+
+type countingStream struct {
+	Stream
+
+	sent, received int
+	closeSent      bool
+}
+
+func (s *countingStream) Send(ctx context.Context, req interface{}) error {
+	s.sent++
+	return s.Stream.Send(ctx, req)
+}
+
+func (s *countingStream) Receive(ctx context.Context, res interface{}) error {
+	s.received++
+	return s.Stream.Receive(ctx, res)
+}
+
+func (s *countingStream) CloseSend() error {
+	s.closeSent = true
+	return s.Stream.CloseSend()
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		stream *countingStream
+		calls  []string
+	)
+	newInterceptor := func(name string) StreamClientInterceptor {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error) {
+			calls = append(calls, name)
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	}
+	counter := func(ctx context.Context, desc *grpc.StreamDesc, cc *ClientConn, method string, streamer Streamer, opts ...CallOption) (Stream, error) {
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		stream = &countingStream{Stream: s}
+		return stream, nil
+	}
+
+	newClient := func(t *testing.T, opt DialOption) *ClientConn {
+		client, err := DialContext(
+			":50051",
+			opt,
+			WithStreamInterceptor(newInterceptor("first")),
+			WithChainStreamInterceptor(newInterceptor("second"), counter),
+		)
+		if err != nil {
+			t.Fatalf("DialContext should not return an error, but got '%s'", err)
+		}
+		return client
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	reqHeader := make(http.Header)
+	reqHeader.Add("content-type", "application/grpc-web+proto")
+	reqHeader.Add("yuko", "aioi")
+
+	t.Run("server stream", func(t *testing.T) {
+		calls = nil
+		r, err := os.Open(filepath.Join("testdata", "server_stream_response.in"))
+		if err != nil {
+			t.Fatalf("Open should not return an error, but got '%s'", err)
+		}
+		trOpt := withUnaryTransport(&unaryTransport{t: t, expectedMD: md, r: r})
+
+		stm, err := newClient(t, trOpt).NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		for {
+			var res api.SimpleResponse
+			if err := stm.Receive(ctx, &res); err != nil {
+				break
+			}
+		}
+		if diff := cmp.Diff([]string{"first", "second"}, calls); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if stream.sent != 1 || stream.received != 4 {
+			t.Errorf("expected 1 Send and 4 Receive, but got %d and %d", stream.sent, stream.received)
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		calls = nil
+		var rs []io.ReadCloser
+		for _, fname := range []string{"client_stream_response1.in", "client_stream_response2.in"} {
+			r, err := os.Open(filepath.Join("testdata", fname))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			rs = append(rs, r)
+		}
+		trOpt := withClientStreamTransport(&clientStreamTransport{tt: t, expectedHeader: reqHeader, r: rs})
+
+		stm, err := newClient(t, trOpt).NewClientStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		var res api.SimpleResponse
+		if err := stm.CloseAndReceive(ctx, &res); err != nil {
+			t.Fatalf("CloseAndReceive should not return an error, but got '%s'", err)
+		}
+		if res.Message == "" {
+			t.Errorf("the response should be received")
+		}
+		if diff := cmp.Diff([]string{"first", "second"}, calls); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if stream.sent != 1 || stream.received != 1 || !stream.closeSent {
+			t.Errorf("expected 1 Send, 1 Receive and CloseSend, but got %d, %d and %t", stream.sent, stream.received, stream.closeSent)
+		}
+	})
+
+	t.Run("bidi stream", func(t *testing.T) {
+		calls = nil
+		var rs []io.ReadCloser
+		for _, fname := range []string{"bidi_stream_response1.in", "bidi_stream_response2.in", "bidi_stream_response3.in", "bidi_stream_response4.in"} {
+			r, err := os.Open(filepath.Join("testdata", fname))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			rs = append(rs, r)
+		}
+		trOpt := withClientStreamTransport(&clientStreamTransport{tt: t, expectedHeader: reqHeader, r: rs})
+
+		stm, err := newClient(t, trOpt).NewBidiStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+		if err != nil {
+			t.Fatalf("NewBidiStream should not return an error, but got '%s'", err)
+		}
+		if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		if err := stm.CloseSend(); err != nil {
+			t.Fatalf("CloseSend should not return an error, but got '%s'", err)
+		}
+		for {
+			var res api.SimpleResponse
+			if err := stm.Receive(ctx, &res); err != nil {
+				break
+			}
+		}
+		if diff := cmp.Diff([]string{"first", "second"}, calls); diff != "" {
+			t.Errorf("-want, +got\n%s", diff)
+		}
+		if stream.sent != 1 || stream.received != 4 || !stream.closeSent {
+			t.Errorf("expected 1 Send, 4 Receive and CloseSend, but got %d, %d and %t", stream.sent, stream.received, stream.closeSent)
+		}
+	})
+}
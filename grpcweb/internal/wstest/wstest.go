@@ -0,0 +1,31 @@
+// Package wstest provides utilities for tests of WebSocket servers compatible with improbable-eng/grpc-web.
+package wstest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+
+// Upgrade upgrades the request to a WebSocket connection.
+// If it fails, it reports the error to t and returns nil.
+func Upgrade(t testing.TB, w http.ResponseWriter, r *http.Request) *websocket.Conn {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		t.Errorf("Upgrade should not return an error, but got '%s'", err)
+		return nil
+	}
+	return conn
+}
+
+// Drain reads messages from conn until it fails, and returns the error.
+func Drain(conn *websocket.Conn) error {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
@@ -1,23 +1,62 @@
 package grpcweb
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/encoding/proto"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Same as grpc/grpc-go clients.
+const (
+	defaultMaxRecvMsgSize = 4 * 1024 * 1024
+	defaultMaxSendMsgSize = math.MaxInt32
 )
 
 var (
-	defaultDialOptions = dialOptions{}
+	defaultDialOptions = dialOptions{
+		maxHeaderListSize: parser.DefaultMaxHeaderListSize,
+	}
 	defaultCallOptions = callOptions{
-		codec: encoding.GetCodec(proto.Name),
+		codec:          encoding.GetCodec(proto.Name),
+		maxRecvMsgSize: defaultMaxRecvMsgSize,
+		maxSendMsgSize: defaultMaxSendMsgSize,
 	}
 )
 
 type dialOptions struct {
-	defaultCallOptions   []CallOption
-	insecure             bool
-	transportCredentials credentials.TransportCredentials
+	defaultCallOptions    []CallOption
+	insecure              bool
+	transportCredentials  credentials.TransportCredentials
+	tlsConfig             *tls.Config
+	unaryInt              UnaryClientInterceptor
+	chainUnaryInts        []UnaryClientInterceptor
+	streamInt             StreamClientInterceptor
+	chainStreamInts       []StreamClientInterceptor
+	httpClient            *http.Client
+	webSocketDialer       *websocket.Dialer
+	contextDialer         func(context.Context, string) (net.Conn, error)
+	unaryTransport        transport.UnaryTransportFactory
+	clientStreamTransport transport.ClientStreamTransportFactory
+	maxHeaderListSize     uint32
+	perRPCCreds           []credentials.PerRPCCredentials
+	tls                   tlsOptions
+	retryPolicy           *RetryPolicy
+	methodRetryPolicies   map[string]*RetryPolicy
 }
 
 type DialOption func(*dialOptions)
@@ -28,27 +67,259 @@ func WithDefaultCallOptions(opts ...CallOption) DialOption {
 	}
 }
 
+// WithInsecure disables transport security. By default, ClientConn uses TLS.
 func WithInsecure() DialOption {
 	return func(opt *dialOptions) {
 		opt.insecure = true
 	}
 }
 
+// WithTransportCredentials configures the TLS settings used by ClientConn.
+// Client and bidi streams use WebSocket, which requires HTTP/1.1, but the credentials always offer HTTP/2.
+// If the server supports HTTP/2, use WithTLSConfig instead.
 func WithTransportCredentials(creds credentials.TransportCredentials) DialOption {
 	return func(opt *dialOptions) {
 		opt.transportCredentials = creds
 	}
 }
 
+// WithTLSConfig configures the TLS settings used by ClientConn.
+// Unary and server streaming RPCs may use HTTP/2, and client and bidi streams always negotiate HTTP/1.1,
+// so all RPCs work against the same HTTP/2-capable host.
+// WithClientCertificateFiles, WithRootCAs and WithPinnedPublicKeys are applied on top of cfg.
+func WithTLSConfig(cfg *tls.Config) DialOption {
+	return func(opt *dialOptions) {
+		opt.tlsConfig = cfg
+	}
+}
+
+// WithClientCertificateFiles returns a DialOption that presents the client certificate for mutual TLS.
+// certFile and keyFile are PEM encoded files. They are reloaded when they are modified,
+// so rotated certificates are used by new connections.
+func WithClientCertificateFiles(certFile, keyFile string) DialOption {
+	return func(opt *dialOptions) {
+		opt.tls.certFile, opt.tls.keyFile = certFile, keyFile
+	}
+}
+
+// WithRootCAs returns a DialOption that specifies the root CAs to verify server certificates.
+// By default, the system pool is used.
+func WithRootCAs(pool *x509.CertPool) DialOption {
+	return func(opt *dialOptions) {
+		opt.tls.rootCAs = pool
+	}
+}
+
+// WithPinnedPublicKeys returns a DialOption that pins public keys of the server.
+// Each pin is a base64-encoded SHA-256 hash of a SubjectPublicKeyInfo, the same format as pin-sha256 of HPKP.
+// The handshake succeeds only if a certificate in the verified chain has one of the pinned keys.
+func WithPinnedPublicKeys(pins ...string) DialOption {
+	return func(opt *dialOptions) {
+		opt.tls.pins = append(opt.tls.pins, pins...)
+	}
+}
+
+// WithHTTPClient returns a DialOption that specifies the HTTP client used for unary and server streaming RPCs.
+// The client is used as it is, so TLS settings and WithContextDialer are not applied to it.
+func WithHTTPClient(c *http.Client) DialOption {
+	return func(opt *dialOptions) {
+		opt.httpClient = c
+	}
+}
+
+// WithWebSocketDialer returns a DialOption that specifies the WebSocket dialer used for client and bidi streaming RPCs.
+// The dialer is used as it is, so TLS settings and WithContextDialer are not applied to it.
+func WithWebSocketDialer(d *websocket.Dialer) DialOption {
+	return func(opt *dialOptions) {
+		opt.webSocketDialer = d
+	}
+}
+
+// WithContextDialer returns a DialOption that specifies a function to create network connections.
+// addr is the host passed to DialContext.
+func WithContextDialer(f func(context.Context, string) (net.Conn, error)) DialOption {
+	return func(opt *dialOptions) {
+		opt.contextDialer = f
+	}
+}
+
+// WithUnaryTransport returns a DialOption that specifies the factory of transports
+// for unary and server streaming RPCs. By default, transport.NewUnary is used.
+func WithUnaryTransport(f transport.UnaryTransportFactory) DialOption {
+	return func(opt *dialOptions) {
+		opt.unaryTransport = f
+	}
+}
+
+// WithClientStreamTransport returns a DialOption that specifies the factory of transports
+// for client and bidi streaming RPCs. By default, transport.NewClientStream is used.
+func WithClientStreamTransport(f transport.ClientStreamTransportFactory) DialOption {
+	return func(opt *dialOptions) {
+		opt.clientStreamTransport = f
+	}
+}
+
+// WithMaxHeaderListSize returns a DialOption that specifies the maximum header list size of trailers
+// the client can receive. The default is parser.DefaultMaxHeaderListSize.
+func WithMaxHeaderListSize(n uint32) DialOption {
+	return func(opt *dialOptions) {
+		opt.maxHeaderListSize = n
+	}
+}
+
+// WithPerRPCCredentials returns a DialOption that sets credentials which attach security information to every RPC.
+// If the credentials require transport security, WithInsecure cannot be used together.
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) DialOption {
+	return func(opt *dialOptions) {
+		opt.perRPCCreds = append(opt.perRPCCreds, creds)
+	}
+}
+
+// WithRetryPolicy returns a DialOption that specifies the default retry policy for unary and
+// server streaming RPCs. RPCs are not retried by default.
+func WithRetryPolicy(p RetryPolicy) DialOption {
+	return func(opt *dialOptions) {
+		opt.retryPolicy = &p
+	}
+}
+
+// WithMethodRetryPolicy returns a DialOption that specifies the retry policy for method.
+// method is a full method name like "/pkg.Service/Method", or a service name like "/pkg.Service"
+// which applies to all methods of the service. It takes precedence over WithRetryPolicy.
+func WithMethodRetryPolicy(method string, p RetryPolicy) DialOption {
+	return func(opt *dialOptions) {
+		if opt.methodRetryPolicies == nil {
+			opt.methodRetryPolicies = make(map[string]*RetryPolicy)
+		}
+		opt.methodRetryPolicies[method] = &p
+	}
+}
+
+// retryPolicyFor returns the retry policy for method, or nil if it is not retried.
+func (o *dialOptions) retryPolicyFor(method string) *RetryPolicy {
+	if p, ok := o.methodRetryPolicies[method]; ok {
+		return p
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		if p, ok := o.methodRetryPolicies[method[:i]]; ok {
+			return p
+		}
+	}
+	return o.retryPolicy
+}
+
+// WithUnaryInterceptor returns a DialOption that specifies the interceptor for unary RPCs.
+func WithUnaryInterceptor(f UnaryClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.unaryInt = f
+	}
+}
+
+// WithChainUnaryInterceptor returns a DialOption that specifies the chained interceptor for unary RPCs.
+// The first interceptor will be the outer most, while the last interceptor will be the inner most wrapper around the real call.
+// All interceptors added by this method will be chained, and the interceptor defined by WithUnaryInterceptor
+// will always be prepended to the chain.
+func WithChainUnaryInterceptor(interceptors ...UnaryClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.chainUnaryInts = append(opt.chainUnaryInts, interceptors...)
+	}
+}
+
+// WithStreamInterceptor returns a DialOption that specifies the interceptor for client, server and bidi streams.
+func WithStreamInterceptor(f StreamClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.streamInt = f
+	}
+}
+
+// WithChainStreamInterceptor returns a DialOption that specifies the chained interceptor for streams.
+// The first interceptor will be the outer most, while the last interceptor will be the inner most wrapper around the real call.
+// All interceptors added by this method will be chained, and the interceptor defined by WithStreamInterceptor
+// will always be prepended to the chain.
+func WithChainStreamInterceptor(interceptors ...StreamClientInterceptor) DialOption {
+	return func(opt *dialOptions) {
+		opt.chainStreamInts = append(opt.chainStreamInts, interceptors...)
+	}
+}
+
 type callOptions struct {
+	// codec is nil if no codec is registered for contentSubtype.
 	codec           encoding.Codec
+	contentSubtype  string
 	header, trailer *metadata.MD
+	peer            *peer.Peer
+	textFormat      bool
+	compressorName  string
+	maxRecvMsgSize  int
+	maxSendMsgSize  int
+	perRPCCreds     credentials.PerRPCCredentials
+
+	// maxHeaderListSize is specified by WithMaxHeaderListSize.
+	maxHeaderListSize uint32
+}
+
+// checkCodec returns an error if no codec is registered for the content-subtype.
+// The message is same as grpc/grpc-go.
+func (o *callOptions) checkCodec() error {
+	if o.codec == nil {
+		return status.Errorf(codes.Internal, "no codec registered for content-subtype %s", o.contentSubtype)
+	}
+	return nil
+}
+
+func (o *callOptions) contentType() string {
+	if o.textFormat {
+		return "application/grpc-web-text+" + o.codec.Name()
+	}
+	return "application/grpc-web+" + o.codec.Name()
+}
+
+// setPeer sets the peer of tr to the address specified by Peer if tr provides it.
+func (o *callOptions) setPeer(tr interface{}) {
+	if o.peer == nil {
+		return
+	}
+	if p, ok := tr.(interface{ Peer() *peer.Peer }); ok {
+		*o.peer = *p.Peer()
+	}
+}
+
+// maxRecvFrameSize returns the maximum payload length of message frames.
+// A negative size is regarded as 0, which rejects every non-empty frame.
+func (o *callOptions) maxRecvFrameSize() uint32 {
+	if o.maxRecvMsgSize < 0 {
+		return 0
+	}
+	if uint64(o.maxRecvMsgSize) > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(o.maxRecvMsgSize)
+}
+
+func (o *callOptions) compressed() bool {
+	return o.compressorName != "" && o.compressorName != "identity"
+}
+
+func (o *callOptions) compressor() (encoding.Compressor, error) {
+	if !o.compressed() {
+		return nil, nil
+	}
+	comp := encoding.GetCompressor(o.compressorName)
+	if comp == nil {
+		return nil, status.Errorf(codes.Internal, "grpc: Compressor is not installed for requested grpc-encoding %q", o.compressorName)
+	}
+	return comp, nil
 }
 
 type CallOption func(*callOptions)
 
+// CallContentSubtype returns a CallOption that specifies the content-subtype and the codec registered for it.
+// Same as grpc/grpc-go, contentSubtype is converted to lowercase, and RPCs fail with codes.Internal
+// if no codec is registered for it.
 func CallContentSubtype(contentSubtype string) CallOption {
+	contentSubtype = strings.ToLower(contentSubtype)
 	return func(opt *callOptions) {
+		opt.contentSubtype = contentSubtype
 		opt.codec = encoding.GetCodec(contentSubtype)
 	}
 }
@@ -66,3 +337,52 @@ func Trailer(t *metadata.MD) CallOption {
 		opt.trailer = t
 	}
 }
+
+// Peer returns a CallOption that retrieves peer information for a call.
+func Peer(p *peer.Peer) CallOption {
+	return func(opt *callOptions) {
+		opt.peer = p
+	}
+}
+
+// UseTextFormat switches the wire format to grpc-web-text.
+// Request and response bodies are encoded in base64.
+func UseTextFormat() CallOption {
+	return func(opt *callOptions) {
+		opt.textFormat = true
+	}
+}
+
+// UseCompressor compresses request messages by the compressor registered as name.
+// The compressor must be registered by encoding.RegisterCompressor.
+func UseCompressor(name string) CallOption {
+	return func(opt *callOptions) {
+		opt.compressorName = name
+	}
+}
+
+// MaxCallRecvMsgSize returns a CallOption that specifies the maximum message size in bytes the client can receive.
+// If a larger message is received, the call fails with codes.ResourceExhausted. The default is 4 MiB.
+// Use WithDefaultCallOptions(MaxCallRecvMsgSize(n)) to change it for all calls.
+func MaxCallRecvMsgSize(n int) CallOption {
+	return func(opt *callOptions) {
+		opt.maxRecvMsgSize = n
+	}
+}
+
+// MaxCallSendMsgSize returns a CallOption that specifies the maximum message size in bytes the client can send.
+// If a larger message is sent, the call fails with codes.ResourceExhausted. The default is math.MaxInt32.
+// Use WithDefaultCallOptions(MaxCallSendMsgSize(n)) to change it for all calls.
+func MaxCallSendMsgSize(n int) CallOption {
+	return func(opt *callOptions) {
+		opt.maxSendMsgSize = n
+	}
+}
+
+// PerRPCCredentials returns a CallOption that sets credentials for a call.
+// They are applied in addition to the credentials specified by WithPerRPCCredentials.
+func PerRPCCredentials(creds credentials.PerRPCCredentials) CallOption {
+	return func(opt *callOptions) {
+		opt.perRPCCreds = creds
+	}
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+)
+
+// NewBase64Reader returns an io.Reader which decodes a grpc-web-text response body incrementally.
+// Unlike base64.NewDecoder, it accepts a body that consists of multiple padded base64 strings,
+// because each frame may be encoded separately.
+func NewBase64Reader(r io.Reader) io.Reader {
+	return &base64Reader{r: bufio.NewReader(r)}
+}
+
+type base64Reader struct {
+	r *bufio.Reader
+
+	quantum [4]byte
+	buf     [3]byte
+	decoded []byte
+	err     error
+}
+
+// Read fills p as much as possible because parsers expect that a header or a message is read at once.
+func (r *base64Reader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if len(r.decoded) != 0 {
+			c := copy(p[n:], r.decoded)
+			r.decoded = r.decoded[c:]
+			n += c
+			continue
+		}
+		if r.err != nil {
+			break
+		}
+		r.err = r.decodeQuantum()
+	}
+	if n != 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// decodeQuantum reads 4 base64 characters and decodes them.
+// Each quantum is decoded individually, so padding characters may appear in the middle of the body.
+func (r *base64Reader) decodeQuantum() error {
+	var i int
+	for i < len(r.quantum) {
+		b, err := r.r.ReadByte()
+		if err == io.EOF && i != 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if b == '\r' || b == '\n' {
+			continue
+		}
+		r.quantum[i] = b
+		i++
+	}
+	n, err := base64.StdEncoding.Decode(r.buf[:], r.quantum[:])
+	if err != nil {
+		return err
+	}
+	r.decoded = r.buf[:n]
+	return nil
+}
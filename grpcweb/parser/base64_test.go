@@ -0,0 +1,100 @@
+package parser_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
+	"github.com/pkg/errors"
+)
+
+func TestBase64Reader(t *testing.T) {
+	cases := map[string]struct {
+		in          string
+		expected    string
+		wantErr     bool
+		expectedErr error
+	}{
+		"no padding": {
+			in:       "aGVsbG8sIGt0cg==",
+			expected: "hello, ktr",
+		},
+		"each frame is padded separately": {
+			in:       "aGVsbG8=LCA=a3Ry",
+			expected: "hello, ktr",
+		},
+		"line breaks": {
+			in:       "aGVs\r\nbG8=\nLCBrdHI=",
+			expected: "hello, ktr",
+		},
+		"empty": {
+			in:       "",
+			expected: "",
+		},
+		"truncated": {
+			in:          "aGVsbG8",
+			wantErr:     true,
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"invalid character": {
+			in:      "aGV*bG8=",
+			wantErr: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			b, err := ioutil.ReadAll(parser.NewBase64Reader(strings.NewReader(c.in)))
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected a error, but got nil")
+				}
+				if c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+					t.Errorf("expected error is '%v', but got '%v'", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not return an error, but got '%s'", err)
+			}
+			if string(b) != c.expected {
+				t.Errorf("expected '%s', but got '%s'", c.expected, b)
+			}
+		})
+	}
+}
+
+func TestBase64ReaderWithParser(t *testing.T) {
+	// A message frame and a trailer frame, each padded separately.
+	in := "AAAAAAIKAA==gAAAABBncnBjLXN0YXR1czogMA0K"
+	r := parser.NewBase64Reader(strings.NewReader(in))
+
+	h, err := parser.ParseResponseHeader(r)
+	if err != nil {
+		t.Fatalf("ParseResponseHeader should not return an error, but got '%s'", err)
+	}
+	if !h.IsMessageHeader() {
+		t.Fatalf("expected a message header")
+	}
+	if _, err := parser.ParseLengthPrefixedMessage(r, h.ContentLength); err != nil {
+		t.Fatalf("ParseLengthPrefixedMessage should not return an error, but got '%s'", err)
+	}
+
+	h, err = parser.ParseResponseHeader(r)
+	if err != nil {
+		t.Fatalf("ParseResponseHeader should not return an error, but got '%s'", err)
+	}
+	if !h.IsTrailerHeader() {
+		t.Fatalf("expected a trailer header")
+	}
+	stat, _, err := parser.ParseStatusAndTrailer(r, h.ContentLength)
+	if err != nil {
+		t.Fatalf("ParseStatusAndTrailer should not return an error, but got '%s'", err)
+	}
+	if stat.Err() != nil {
+		t.Errorf("expected OK status, but got '%s'", stat.Err())
+	}
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// headerLen is the length of a frame header, which consists of a flag (1 byte) and a length (4 bytes).
+const headerLen = 5
+
+// FrameReader reads gRPC-Web frames one by one.
+// It tolerates short reads of the underlying reader, so it can be used with chunked HTTP bodies or TLS records.
+// Unlike ParseResponseHeader, it accepts zero-length frames.
+type FrameReader struct {
+	// MaxMessageSize is the maximum payload length of message frames.
+	// The default is math.MaxUint32, which means no limit.
+	MaxMessageSize uint32
+	// MaxTrailerSize is the maximum payload length of trailer frames.
+	// The default is math.MaxUint32, which means no limit.
+	MaxTrailerSize uint32
+
+	r      io.Reader
+	header [headerLen]byte
+	buf    []byte
+}
+
+// FrameSizeError is returned by ReadFrame if the payload length of the frame exceeds the limit.
+// The payload of the frame is not read.
+type FrameSizeError struct {
+	Header *Header
+	Max    uint32
+}
+
+func (e *FrameSizeError) Error() string {
+	kind := "message"
+	if e.Header.IsTrailerHeader() {
+		kind = "trailer"
+	}
+	return fmt.Sprintf("%s frame larger than max (%d vs. %d)", kind, e.Header.ContentLength, e.Max)
+}
+
+// NewFrameReader returns a new FrameReader that reads frames from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{
+		MaxMessageSize: math.MaxUint32,
+		MaxTrailerSize: math.MaxUint32,
+		r:              r,
+	}
+}
+
+// ReadFrame reads the next message or trailer frame.
+// The returned payload is valid until the next call of ReadFrame, because the buffer is reused.
+//
+// It returns io.EOF if there are no more frames, and io.ErrUnexpectedEOF if a frame is truncated.
+func (r *FrameReader) ReadFrame() (*Header, []byte, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, err
+		}
+		return nil, nil, errors.Wrap(err, "failed to read the frame header")
+	}
+	h := &Header{
+		flag:          r.header[0],
+		ContentLength: binary.BigEndian.Uint32(r.header[1:]),
+	}
+
+	max := r.MaxMessageSize
+	if h.IsTrailerHeader() {
+		max = r.MaxTrailerSize
+	}
+	if h.ContentLength > max {
+		return nil, nil, &FrameSizeError{Header: h, Max: max}
+	}
+
+	if uint32(cap(r.buf)) < h.ContentLength {
+		r.buf = make([]byte, h.ContentLength)
+	}
+	r.buf = r.buf[:h.ContentLength]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return nil, nil, errors.Wrap(err, "failed to read the frame payload")
+	}
+	return h, r.buf, nil
+}
@@ -0,0 +1,145 @@
+package parser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
+)
+
+func frame(flag byte, payload string) []byte {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func TestFrameReader(t *testing.T) {
+	type frameType struct {
+		trailer, compressed bool
+		payload             string
+	}
+
+	in := bytes.Join([][]byte{
+		frame(0x00, "hello"),
+		frame(0x00, ""),
+		frame(0x01, "compressed message"),
+		frame(0x80, "grpc-status: 0\r\n"),
+	}, nil)
+	expected := []frameType{
+		{payload: "hello"},
+		{payload: ""},
+		{compressed: true, payload: "compressed message"},
+		{trailer: true, payload: "grpc-status: 0\r\n"},
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"normal":   func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+		"data err": iotest.DataErrReader,
+	}
+
+	for name, newReader := range readers {
+		newReader := newReader
+		t.Run(name, func(t *testing.T) {
+			r := parser.NewFrameReader(newReader(bytes.NewReader(in)))
+			var got []frameType
+			for {
+				h, b, err := r.ReadFrame()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadFrame should not return an error, but got '%s'", err)
+				}
+				if h.ContentLength != uint32(len(b)) {
+					t.Errorf("expected content length is %d, but got %d", len(b), h.ContentLength)
+				}
+				got = append(got, frameType{trailer: h.IsTrailerHeader(), compressed: h.IsCompressed(), payload: string(b)})
+			}
+			if diff := cmp.Diff(expected, got, cmp.AllowUnexported(frameType{})); diff != "" {
+				t.Errorf("-want, +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFrameReaderError(t *testing.T) {
+	cases := map[string]struct {
+		in          []byte
+		expectedErr error
+	}{
+		"empty": {
+			in:          nil,
+			expectedErr: io.EOF,
+		},
+		"truncated header": {
+			in:          []byte{0x00, 0x00, 0x00},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"missing payload": {
+			in:          []byte{0x00, 0x00, 0x00, 0x00, 0x05},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"truncated payload": {
+			in:          frame(0x00, "hello")[:8],
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			r := parser.NewFrameReader(iotest.OneByteReader(bytes.NewReader(c.in)))
+			if _, _, err := r.ReadFrame(); err != c.expectedErr {
+				t.Errorf("expected error is '%v', but got '%v'", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFrameReaderSizeLimit(t *testing.T) {
+	cases := map[string]struct {
+		in                             []byte
+		maxMessageSize, maxTrailerSize uint32
+		expectedMax                    uint32
+	}{
+		"message": {
+			in:             frame(0x00, "hello"),
+			maxMessageSize: 4,
+			maxTrailerSize: 8,
+			expectedMax:    4,
+		},
+		"trailer": {
+			in:             frame(0x80, "grpc-status: 0\r\n"),
+			maxMessageSize: 4,
+			maxTrailerSize: 8,
+			expectedMax:    8,
+		},
+		"zero limit": {
+			in:          frame(0x00, "hello"),
+			expectedMax: 0,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			r := parser.NewFrameReader(bytes.NewReader(c.in))
+			r.MaxMessageSize, r.MaxTrailerSize = c.maxMessageSize, c.maxTrailerSize
+			_, _, err := r.ReadFrame()
+			serr, ok := err.(*parser.FrameSizeError)
+			if !ok {
+				t.Fatalf("expected error is *parser.FrameSizeError, but got '%v'", err)
+			}
+			if serr.Max != c.expectedMax {
+				t.Errorf("expected max is %d, but got %d", c.expectedMax, serr.Max)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"io"
@@ -21,6 +22,11 @@ type Header struct {
 	ContentLength uint32
 }
 
+// Flag returns the raw flag byte of the frame header.
+func (h *Header) Flag() byte {
+	return h.flag
+}
+
 func (h *Header) IsMessageHeader() bool {
 	return h.flag == 0 || h.flag == 1
 }
@@ -29,15 +35,24 @@ func (h *Header) IsTrailerHeader() bool {
 	return h.flag>>7 == 0x01
 }
 
+// IsCompressed reports whether the frame is compressed.
+// It is valid for both message and trailer frames.
+func (h *Header) IsCompressed() bool {
+	return h.flag&0x01 == 0x01
+}
+
+// ParseResponseHeader reads a frame header from r.
+// It returns io.EOF if the length of the frame is zero.
+//
+// Deprecated: Use FrameReader, which also accepts zero-length frames.
 func ParseResponseHeader(r io.Reader) (*Header, error) {
-	var h [5]byte
-	n, err := r.Read(h[:])
-	if err != nil {
+	var h [headerLen]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.ErrUnexpectedEOF
+		}
 		return nil, errors.Wrap(err, "failed to read header")
 	}
-	if n != len(h) {
-		return nil, io.ErrUnexpectedEOF
-	}
 
 	length := binary.BigEndian.Uint32(h[1:])
 	if length == 0 {
@@ -49,46 +64,85 @@ func ParseResponseHeader(r io.Reader) (*Header, error) {
 	}, nil
 }
 
+// ParseLengthPrefixedMessage reads the payload of a frame which has length bytes from r.
+// Same as ParseResponseHeader, it returns io.EOF if length is zero.
+//
+// Deprecated: Use FrameReader.
 func ParseLengthPrefixedMessage(r io.Reader, length uint32) ([]byte, error) {
-	content := make([]byte, length)
-	n, err := r.Read(content)
-	switch {
-	case uint32(n) != length:
-		return nil, io.ErrUnexpectedEOF
-	case err == io.EOF:
+	if length == 0 {
 		return nil, io.EOF
-	case err != nil:
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
 	return content, nil
 }
 
+// DefaultMaxHeaderListSize is the default maximum header list size of trailers.
+// It is the same as the default of grpc/grpc-go clients.
+const DefaultMaxHeaderListSize = 16 << 20
+
+// ErrHeaderListTooLarge is returned if the header list size of trailers exceeds the limit.
+var ErrHeaderListTooLarge = errors.New("header list size of the trailer exceeds the limit")
+
+// ErrMalformedTrailer is returned if a line of the trailer block is not a header field.
+var ErrMalformedTrailer = errors.New("malformed trailer")
+
+// ParseStatusAndTrailer parses the trailer block which has length bytes.
+// The header list size is limited by DefaultMaxHeaderListSize.
 func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata.MD, error) {
+	return ParseStatusAndTrailerWithLimit(r, length, DefaultMaxHeaderListSize)
+}
+
+// ParseStatusAndTrailerWithLimit is the same as ParseStatusAndTrailer, but it limits the header list size
+// to maxHeaderListSize. Same as HTTP/2, the size of each field is the length of the key and the value plus 32 bytes.
+// A line of the trailer block can be as long as maxHeaderListSize.
+//
+// The trailer block consists of HTTP/1 header fields terminated by CRLF. For compatibility, LF and
+// the missing terminator of the last line are also accepted. It reads exactly length bytes from r,
+// and returns io.ErrUnexpectedEOF if r has fewer bytes.
+func ParseStatusAndTrailerWithLimit(r io.Reader, length, maxHeaderListSize uint32) (*status.Status, metadata.MD, error) {
 	var (
-		readLen    uint32
+		listSize   uint64
 		headerStat *status.Status
 		code       codes.Code
 		msg        string
 	)
+	// Same as grpc/grpc-go, the status is codes.Unknown if grpc-status is missing.
+	code = codes.Unknown
+	lr := &io.LimitedReader{R: r, N: int64(length)}
+	br := bufio.NewReader(lr)
 	trailer := metadata.New(nil)
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		readLen += uint32(len(s.Bytes()))
-		if readLen > length {
-			return nil, nil, io.ErrUnexpectedEOF
+	for {
+		t, err := readLine(br, maxHeaderListSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if t == "" {
+			continue
 		}
 
-		t := s.Text()
-		i := strings.Index(t, ":")
-		if i == -1 {
-			return nil, nil, io.ErrUnexpectedEOF
+		i := strings.IndexByte(t, ':')
+		if i <= 0 {
+			return nil, nil, errors.Wrapf(ErrMalformedTrailer, "invalid header field %q", t)
 		}
 
 		// Check reserved keys.
-		k, v := strings.ToLower(t[:i]), strings.TrimSpace(t[i+1:])
+		k, v := strings.ToLower(t[:i]), strings.TrimLeft(t[i+1:], " \t")
+		listSize += uint64(len(k) + len(v) + 32)
+		if listSize > uint64(maxHeaderListSize) {
+			return nil, nil, ErrHeaderListTooLarge
+		}
 		switch k {
 		case "grpc-status":
-			n, err := strconv.ParseUint(v, 10, 32)
+			n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
 			if err != nil {
 				code = codes.Unknown
 			} else {
@@ -96,10 +150,10 @@ func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata
 			}
 			continue
 		case "grpc-message":
-			msg = v
+			msg = DecodeGRPCMessage(v)
 			continue
 		case "grpc-status-details-bin":
-			b, err := decodeBase64Value(v)
+			b, err := decodeBase64Value(strings.TrimSpace(v))
 			if err != nil {
 				// Same behavior as grpc/grpc-go.
 				return status.Newf(
@@ -119,9 +173,16 @@ func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata
 			}
 			headerStat = status.FromProto(s)
 		default:
+			if IsBinHeader(k) {
+				trailer.Append(k, DecodeBinValues(v)...)
+				continue
+			}
 			trailer.Append(k, v)
 		}
 	}
+	if lr.N != 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
 
 	var stat *status.Status
 	if headerStat != nil {
@@ -136,6 +197,84 @@ func ParseStatusAndTrailer(r io.Reader, length uint32) (*status.Status, metadata
 	return stat, trailer, nil
 }
 
+// readLine reads a line terminated by LF or the end of r. The terminator and the preceding CR are removed.
+// It returns ErrHeaderListTooLarge if the line is longer than max.
+func readLine(r *bufio.Reader, max uint32) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		// Allow CRLF in addition to max.
+		if uint64(len(line)+len(b)) > uint64(max)+2 {
+			return "", ErrHeaderListTooLarge
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) != 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+// DecodeGRPCMessage percent-decodes grpc-message. Invalid escape sequences are kept as they are.
+// It is mostly copied from http_util.go in grpc/grpc-go.
+func DecodeGRPCMessage(msg string) string {
+	if !strings.Contains(msg, "%") {
+		return msg
+	}
+	var buf bytes.Buffer
+	lenMsg := len(msg)
+	for i := 0; i < lenMsg; i++ {
+		c := msg[i]
+		if c == '%' && i+2 < lenMsg {
+			parsed, err := strconv.ParseUint(msg[i+1:i+3], 16, 8)
+			if err != nil {
+				buf.WriteByte(c)
+			} else {
+				buf.WriteByte(byte(parsed))
+				i += 2
+			}
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// IsBinHeader returns true if k is a binary header, which has "-bin" suffix.
+func IsBinHeader(k string) bool {
+	return strings.HasSuffix(strings.ToLower(k), "-bin")
+}
+
+// EncodeBinHeader encodes the value of a binary header in base64 without padding.
+// It is mostly copied from http_util.go in grpc/grpc-go.
+func EncodeBinHeader(v []byte) string {
+	return base64.RawStdEncoding.EncodeToString(v)
+}
+
+// DecodeBinValues decodes comma-separated values of a binary header, which may be combined by proxies.
+// If any of them cannot be decoded, v is returned as it is.
+func DecodeBinValues(v string) []string {
+	parts := strings.Split(v, ",")
+	vs := make([]string, 0, len(parts))
+	for _, s := range parts {
+		b, err := decodeBase64Value(strings.TrimSpace(s))
+		if err != nil {
+			return []string{v}
+		}
+		vs = append(vs, string(b))
+	}
+	return vs
+}
+
 func decodeBase64Value(v string) ([]byte, error) {
 	// Mostly copied from http_util.go in grpc/grpc-go.
 
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -27,6 +28,7 @@ func TestParseResponseHeader(t *testing.T) {
 		in                    []byte
 		expectedHeaderType    headerType
 		expectedContentLength uint32
+		expectedCompressed    bool
 		wantErr               bool
 		expectedErr           error
 	}{
@@ -40,6 +42,18 @@ func TestParseResponseHeader(t *testing.T) {
 			expectedContentLength: 72,
 			expectedHeaderType:    trailer,
 		},
+		"compressed message header": {
+			in:                    []byte{0x01, 0x00, 0x00, 0x00, 0x0c},
+			expectedContentLength: 12,
+			expectedHeaderType:    message,
+			expectedCompressed:    true,
+		},
+		"compressed trailer header": {
+			in:                    []byte{0x81, 0x00, 0x00, 0x00, 0x48},
+			expectedContentLength: 72,
+			expectedHeaderType:    trailer,
+			expectedCompressed:    true,
+		},
 		"unexpected error": {
 			in:          []byte{0x80},
 			wantErr:     true,
@@ -76,6 +90,9 @@ func TestParseResponseHeader(t *testing.T) {
 			if v, ok := m[c.expectedHeaderType]; !v || !ok {
 				t.Errorf("header type is not %d", c.expectedHeaderType)
 			}
+			if h.IsCompressed() != c.expectedCompressed {
+				t.Errorf("expected IsCompressed is %t, but got %t", c.expectedCompressed, h.IsCompressed())
+			}
 		})
 	}
 }
@@ -181,14 +198,81 @@ func TestParseStatusAndTrailer(t *testing.T) {
 				"trailer_key2": "trailer_val2",
 			}),
 		},
+		"ok with binary metadata": {
+			fname:          "status_trailer_bin.in",
+			expectedStatus: status.New(codes.OK, ""),
+			expectedTrailer: metadata.New(map[string]string{
+				"trailer_key1": "trailer_val1",
+				"trace-bin":    "\x00\x01\x02",
+			}),
+		},
+		"comma-separated and malformed binary metadata": {
+			fname:          "status_trailer_bin_values.in",
+			expectedStatus: status.New(codes.NotFound, "not found"),
+			expectedTrailer: metadata.MD{
+				"a-bin": []string{"\x00\x01", "\x02\x03"},
+				"b-bin": []string{"AAE, !!"},
+			},
+		},
+		"percent-encoded message": {
+			fname:          "status_trailer_percent_encoded.in",
+			expectedStatus: status.New(codes.NotFound, "not found: 100% \u3042 %zz"),
+		},
+		"multi-valued keys": {
+			fname:          "status_trailer_multi_values.in",
+			expectedStatus: status.New(codes.OK, ""),
+			expectedTrailer: metadata.MD{
+				"key1": []string{"val1", "val2"},
+				"key2": []string{"val3"},
+			},
+		},
+		"LF and no terminator at the end": {
+			fname:          "status_trailer_lf.in",
+			expectedStatus: status.New(codes.Internal, "internal error"),
+			expectedTrailer: metadata.New(map[string]string{
+				"trailer_key1": "trailer_val1",
+			}),
+		},
+		"whitespaces and empty lines": {
+			fname:          "status_trailer_whitespace.in",
+			expectedStatus: status.New(codes.Internal, "internal error  "),
+			expectedTrailer: metadata.New(map[string]string{
+				"trailer_key1": "trailer_val1",
+			}),
+		},
 		"bytes exceeds length": {
+			fname:          "status_trailer.in",
+			length:         uint32(len("grpc-status: 0\r\n")),
+			expectedStatus: status.New(codes.OK, ""),
+		},
+		"length exceeds bytes": {
 			fname:       "status_trailer.in",
-			length:      3,
+			length:      1024,
 			expectedErr: io.ErrUnexpectedEOF,
 		},
+		"truncated line": {
+			fname:       "status_trailer.in",
+			length:      3,
+			expectedErr: parser.ErrMalformedTrailer,
+		},
 		"invalid metadata": {
 			fname:       "status_trailer_invalid_metadata.in",
-			expectedErr: io.ErrUnexpectedEOF,
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"empty key": {
+			fname:       "status_trailer_empty_key.in",
+			expectedErr: parser.ErrMalformedTrailer,
+		},
+		"missing status": {
+			fname:          "status_trailer_missing_status.in",
+			expectedStatus: status.New(codes.Unknown, ""),
+			expectedTrailer: metadata.New(map[string]string{
+				"trailer_key1": "trailer_val1",
+			}),
+		},
+		"empty trailer": {
+			fname:          "status_trailer_empty.in",
+			expectedStatus: status.New(codes.Unknown, ""),
 		},
 		"invalid status": {
 			fname:          "status_trailer_invalid_status.in",
@@ -214,7 +298,7 @@ func TestParseStatusAndTrailer(t *testing.T) {
 				c.length = uint32(in.Len())
 			}
 			status, trailer, err := parser.ParseStatusAndTrailer(in, c.length)
-			if err != c.expectedErr {
+			if !errors.Is(err, c.expectedErr) {
 				t.Errorf("expected error: '%s', but got '%s'", c.expectedErr, err)
 				if err != nil {
 					return
@@ -229,3 +313,53 @@ func TestParseStatusAndTrailer(t *testing.T) {
 		})
 	}
 }
+
+func TestParseStatusAndTrailerWithLimit(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "status_trailer.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+
+	// status_trailer.in has 3 fields.
+	if _, _, err := parser.ParseStatusAndTrailerWithLimit(bytes.NewReader(b), uint32(len(b)), 32*3); err != parser.ErrHeaderListTooLarge {
+		t.Errorf("expected error: '%s', but got '%v'", parser.ErrHeaderListTooLarge, err)
+	}
+	if _, _, err := parser.ParseStatusAndTrailerWithLimit(bytes.NewReader(b), uint32(len(b)), parser.DefaultMaxHeaderListSize); err != nil {
+		t.Errorf("should not return an error, but got '%s'", err)
+	}
+}
+
+func TestParseStatusAndTrailerLongLine(t *testing.T) {
+	// Longer than the default buffer size of bufio.Scanner.
+	b := []byte("grpc-status: 0\r\nkey: " + strings.Repeat("a", 100*1024) + "\r\n")
+
+	_, trailer, err := parser.ParseStatusAndTrailer(bytes.NewReader(b), uint32(len(b)))
+	if err != nil {
+		t.Fatalf("should not return an error, but got '%s'", err)
+	}
+	if n := len(trailer.Get("key")[0]); n != 100*1024 {
+		t.Errorf("expected length of the value is %d, but got %d", 100*1024, n)
+	}
+
+	_, _, err = parser.ParseStatusAndTrailerWithLimit(bytes.NewReader(b), uint32(len(b)), 64*1024)
+	if err != parser.ErrHeaderListTooLarge {
+		t.Errorf("expected error: '%s', but got '%v'", parser.ErrHeaderListTooLarge, err)
+	}
+}
+
+func TestDecodeGRPCMessage(t *testing.T) {
+	cases := map[string]string{
+		"":                  "",
+		"hello":             "hello",
+		"100%25":            "100%",
+		"%E3%81%82":         "\u3042",
+		"%zz":               "%zz",
+		"%4":                "%4",
+		"line1%0D%0Aline2%": "line1\r\nline2%",
+	}
+	for in, expected := range cases {
+		if got := parser.DecodeGRPCMessage(in); got != expected {
+			t.Errorf("DecodeGRPCMessage(%q): expected '%s', but got '%s'", in, expected, got)
+		}
+	}
+}
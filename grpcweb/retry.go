@@ -0,0 +1,146 @@
+package grpcweb
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxRetryAttempts is the upper limit of RetryPolicy.MaxAttempts. Same as grpc/grpc-go.
+const maxRetryAttempts = 5
+
+// RetryPolicy is the retry policy for unary and server streaming RPCs.
+// Same as the retry policy of the gRPC service config, the delay before the n-th retry is
+// a random value between 0 and min(InitialBackoff*BackoffMultiplier^(n-1), MaxBackoff).
+// If the server pushes back with grpc-retry-pushback-ms or RetryInfo, the delay is used instead
+// and the backoff is reset.
+//
+// Server streaming RPCs are not retried once a message has been received.
+//
+// spec: https://github.com/grpc/proposal/blob/master/A6-client-retries.md
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the original RPC.
+	// It must be greater than 1, and values greater than 5 are treated as 5.
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableStatusCodes is the status codes which may be retried. It must not be empty.
+	RetryableStatusCodes []codes.Code
+}
+
+func (p *RetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts <= 1:
+		return errors.New("MaxAttempts must be greater than 1")
+	case p.InitialBackoff <= 0:
+		return errors.New("InitialBackoff must be greater than 0")
+	case p.MaxBackoff <= 0:
+		return errors.New("MaxBackoff must be greater than 0")
+	case p.BackoffMultiplier <= 0:
+		return errors.New("BackoffMultiplier must be greater than 0")
+	case len(p.RetryableStatusCodes) == 0:
+		return errors.New("RetryableStatusCodes must not be empty")
+	}
+	return nil
+}
+
+func (p *RetryPolicy) retryable(code codes.Code) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryState is the state of retries of an RPC.
+type retryState struct {
+	// policy is nil if the RPC is not retried.
+	policy *RetryPolicy
+	// attempts is the number of the previous attempts.
+	attempts int
+	// backoffs is the number of backoffs since the last pushback.
+	backoffs int
+}
+
+// retry waits for the delay before the next attempt, and returns true if the next attempt should be made.
+// err is the error of the last attempt, and trailer is its trailer if it has been received.
+func (s *retryState) retry(ctx context.Context, err error, trailer metadata.MD) bool {
+	if s.policy == nil || ctx.Err() != nil {
+		return false
+	}
+	maxAttempts := s.policy.MaxAttempts
+	if maxAttempts > maxRetryAttempts {
+		maxAttempts = maxRetryAttempts
+	}
+	if s.attempts+1 >= maxAttempts {
+		return false
+	}
+	st := status.Convert(toStatusError(err))
+	if !s.policy.retryable(st.Code()) {
+		return false
+	}
+
+	d, pushback, ok := pushbackDelay(st, trailer)
+	if !ok {
+		return false
+	}
+	if pushback {
+		s.backoffs = 0
+	} else {
+		cur := float64(s.policy.InitialBackoff) * math.Pow(s.policy.BackoffMultiplier, float64(s.backoffs))
+		if max := float64(s.policy.MaxBackoff); cur > max {
+			cur = max
+		}
+		d = time.Duration(rand.Int63n(int64(cur) + 1))
+		s.backoffs++
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+	}
+	s.attempts++
+	return true
+}
+
+// pushbackDelay returns the delay specified by grpc-retry-pushback-ms or RetryInfo.
+// ok is false if the server pushes back not to retry.
+func pushbackDelay(st *status.Status, trailer metadata.MD) (d time.Duration, pushback, ok bool) {
+	switch v := trailer.Get("grpc-retry-pushback-ms"); len(v) {
+	case 0:
+	case 1:
+		ms, err := strconv.Atoi(v[0])
+		if err != nil || ms < 0 {
+			return 0, false, false
+		}
+		return time.Duration(ms) * time.Millisecond, true, true
+	default:
+		return 0, false, false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.RetryInfo)
+		if !ok || info.GetRetryDelay() == nil {
+			continue
+		}
+		d, err := ptypes.Duration(info.GetRetryDelay())
+		if err != nil || d < 0 {
+			return 0, false, false
+		}
+		return d, true, true
+	}
+	return 0, false, true
+}
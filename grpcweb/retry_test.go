@@ -0,0 +1,325 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/ktr0731/grpc-test/api"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// retryResponse is a response of an attempt. If fileName is empty, the response has no body.
+type retryResponse struct {
+	header   http.Header
+	fileName string
+	err      error
+}
+
+func unavailableResponse() retryResponse {
+	return retryResponse{header: http.Header{"Grpc-Status": []string{"14"}}}
+}
+
+// withRetryResponses returns a DialOption which responds with responses in order.
+// The returned function returns the request headers of the attempts.
+func withRetryResponses(t *testing.T, md metadata.MD, responses ...retryResponse) (DialOption, func() []http.Header) {
+	var (
+		mu  sync.Mutex
+		trs []*unaryTransport
+	)
+	opt := WithUnaryTransport(func(string, *transport.ConnectOptions) transport.UnaryTransport {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(trs) == len(responses) {
+			t.Fatalf("unexpected attempt: %d", len(trs)+1)
+		}
+		res := responses[len(trs)]
+		r := ioutil.NopCloser(bytes.NewReader(nil))
+		if res.fileName != "" {
+			f, err := os.Open(filepath.Join("testdata", res.fileName))
+			if err != nil {
+				t.Fatalf("Open should not return an error, but got '%s'", err)
+			}
+			r = f
+		}
+		tr := &unaryTransport{t: t, expectedMD: md, h: res.header, r: r, err: res.err}
+		trs = append(trs, tr)
+		return tr
+	})
+	return opt, func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		headers := make([]http.Header, 0, len(trs))
+		for _, tr := range trs {
+			headers = append(headers, tr.reqHeader)
+		}
+		return headers
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	retryInfo := status.New(codes.Unavailable, "unavailable")
+	retryInfo, err := retryInfo.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(time.Millisecond)})
+	if err != nil {
+		t.Fatalf("WithDetails should not return an error, but got '%s'", err)
+	}
+	b, err := proto.Marshal(retryInfo.Proto())
+	if err != nil {
+		t.Fatalf("proto.Marshal should not return an error, but got '%s'", err)
+	}
+
+	policy := RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           10 * time.Millisecond,
+		BackoffMultiplier:    2,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable},
+	}
+	// slowPolicy makes the test time out if the pushback is not honored.
+	slowPolicy := policy
+	slowPolicy.InitialBackoff, slowPolicy.MaxBackoff = time.Hour, time.Hour
+
+	ok := retryResponse{fileName: "response.in"}
+	cases := map[string]struct {
+		policy           RetryPolicy
+		responses        []retryResponse
+		expectedCode     codes.Code
+		expectedAttempts int
+	}{
+		"succeeded after retries": {
+			policy:           policy,
+			responses:        []retryResponse{unavailableResponse(), unavailableResponse(), ok},
+			expectedAttempts: 3,
+		},
+		"exceeded max attempts": {
+			policy:           policy,
+			responses:        []retryResponse{unavailableResponse(), unavailableResponse(), unavailableResponse()},
+			expectedCode:     codes.Unavailable,
+			expectedAttempts: 3,
+		},
+		"non-retryable status": {
+			policy:           policy,
+			responses:        []retryResponse{{header: http.Header{"Grpc-Status": []string{"5"}}}},
+			expectedCode:     codes.NotFound,
+			expectedAttempts: 1,
+		},
+		"status in the trailer frame": {
+			policy: RetryPolicy{
+				MaxAttempts:          2,
+				InitialBackoff:       time.Millisecond,
+				MaxBackoff:           time.Millisecond,
+				BackoffMultiplier:    1,
+				RetryableStatusCodes: []codes.Code{codes.Internal},
+			},
+			responses:        []retryResponse{{fileName: "trailer_response_error.in"}, ok},
+			expectedAttempts: 2,
+		},
+		"HTTP error": {
+			policy:           policy,
+			responses:        []retryResponse{{err: &transport.HTTPStatusError{StatusCode: http.StatusServiceUnavailable}}, ok},
+			expectedAttempts: 2,
+		},
+		"pushback": {
+			policy: slowPolicy,
+			responses: []retryResponse{
+				{header: http.Header{"Grpc-Status": []string{"14"}, "Grpc-Retry-Pushback-Ms": []string{"1"}}},
+				ok,
+			},
+			expectedAttempts: 2,
+		},
+		"negative pushback": {
+			policy:           policy,
+			responses:        []retryResponse{{header: http.Header{"Grpc-Status": []string{"14"}, "Grpc-Retry-Pushback-Ms": []string{"-1"}}}},
+			expectedCode:     codes.Unavailable,
+			expectedAttempts: 1,
+		},
+		"RetryInfo": {
+			policy: slowPolicy,
+			responses: []retryResponse{
+				{header: http.Header{
+					"Grpc-Status":             []string{"14"},
+					"Grpc-Status-Details-Bin": []string{base64.RawStdEncoding.EncodeToString(b)},
+				}},
+				ok,
+			},
+			expectedAttempts: 2,
+		},
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), 10*time.Second)
+			defer cancel()
+
+			trOpt, headers := withRetryResponses(t, md, c.responses...)
+			client, err := DialContext(":50051", trOpt, WithRetryPolicy(c.policy))
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			var res api.SimpleResponse
+			err = client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res)
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s ('%v')", c.expectedCode, code, err)
+			}
+			assertAttempts(t, headers(), c.expectedAttempts)
+		})
+	}
+}
+
+// assertAttempts asserts the number of attempts and their grpc-previous-rpc-attempts headers.
+func assertAttempts(t *testing.T, headers []http.Header, expected int) {
+	t.Helper()
+	if len(headers) != expected {
+		t.Fatalf("expected %d attempts, but got %d", expected, len(headers))
+	}
+	for i, h := range headers {
+		var want []string
+		if i > 0 {
+			want = []string{strconv.Itoa(i)}
+		}
+		if diff := cmp.Diff(want, h["Grpc-Previous-Rpc-Attempts"]); diff != "" {
+			t.Errorf("attempt %d: -want, +got\n%s", i+1, diff)
+		}
+	}
+}
+
+func TestRetryServerStream(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           time.Millisecond,
+		BackoffMultiplier:    1,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable, codes.Internal},
+	}
+
+	cases := map[string]struct {
+		responses        []retryResponse
+		expectedMessages int
+		expectedCode     codes.Code
+		expectedAttempts int
+	}{
+		"retried before the first message": {
+			responses: []retryResponse{
+				{err: &transport.HTTPStatusError{StatusCode: http.StatusServiceUnavailable}},
+				unavailableResponse(),
+				{fileName: "server_stream_response.in"},
+			},
+			expectedMessages: 3,
+			expectedAttempts: 3,
+		},
+		"not retried after the first message": {
+			responses:        []retryResponse{{fileName: "server_stream_trailer_response_error.in"}},
+			expectedMessages: 1,
+			expectedCode:     codes.Internal,
+			expectedAttempts: 1,
+		},
+	}
+
+	md := metadata.Pairs("yuko", "aioi")
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), 10*time.Second)
+			defer cancel()
+
+			trOpt, headers := withRetryResponses(t, md, c.responses...)
+			client, err := DialContext(":50051", trOpt, WithRetryPolicy(policy))
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+
+			stm, err := client.NewServerStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/service/Method")
+			if err != nil {
+				t.Fatalf("NewServerStream should not return an error, but got '%s'", err)
+			}
+			if err := stm.Send(ctx, &api.SimpleRequest{Name: "nano"}); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			var n int
+			for {
+				var res api.SimpleResponse
+				err = stm.Receive(ctx, &res)
+				if err != nil {
+					break
+				}
+				n++
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s ('%v')", c.expectedCode, code, err)
+			}
+			if n != c.expectedMessages {
+				t.Errorf("expected %d messages, but got %d", c.expectedMessages, n)
+			}
+			assertAttempts(t, headers(), c.expectedAttempts)
+		})
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	t.Parallel()
+
+	p := func(n int) RetryPolicy {
+		return RetryPolicy{
+			MaxAttempts:          n,
+			InitialBackoff:       time.Second,
+			MaxBackoff:           time.Second,
+			BackoffMultiplier:    1,
+			RetryableStatusCodes: []codes.Code{codes.Unavailable},
+		}
+	}
+	client, err := DialContext(":50051",
+		WithRetryPolicy(p(2)),
+		WithMethodRetryPolicy("/pkg.Service", p(3)),
+		WithMethodRetryPolicy("/pkg.Service/Method", p(4)),
+	)
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+
+	cases := map[string]int{
+		"/pkg.Service/Method":  4,
+		"/pkg.Service/Other":   3,
+		"/pkg.Another/Method":  2,
+		"/pkg.Service2/Method": 2,
+	}
+	for method, expected := range cases {
+		if n := client.dialOptions.retryPolicyFor(method).MaxAttempts; n != expected {
+			t.Errorf("%s: expected MaxAttempts is %d, but got %d", method, expected, n)
+		}
+	}
+
+	if _, err := DialContext(":50051", WithMethodRetryPolicy("/pkg.Service/Method", p(1))); err == nil {
+		t.Errorf("DialContext should return an error for an invalid retry policy, but got nil")
+	}
+}
@@ -1,17 +1,20 @@
 package grpcweb
 
 import (
+	"bytes"
 	"context"
-	"encoding/binary"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/frame"
 	"github.com/ktr0731/grpc-web-go-client/grpcweb/parser"
 	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
 	"github.com/pkg/errors"
 	"go.uber.org/atomic"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -30,13 +33,22 @@ type ClientStream interface {
 }
 
 type clientStream struct {
+	// ctx is the context bound to the stream.
+	ctx         context.Context
 	endpoint    string
 	transport   transport.ClientStreamTransport
 	callOptions *callOptions
+	// reqHeader is the request header, which is sent in the header frame by the first Send.
+	reqHeader  http.Header
+	headerOnce sync.Once
 
-	trailersOnly, closed atomic.Bool
-	headerMu, trailerMu  sync.RWMutex
-	headerMD, trailerMD  metadata.MD
+	// stopWatch stops closing transport when ctx is done, and releases ctx.
+	stopWatch  func()
+	finishOnce sync.Once
+
+	trailersOnly, closed, received atomic.Bool
+	headerMu, trailerMu            sync.RWMutex
+	headerMD, trailerMD            metadata.MD
 }
 
 func (s *clientStream) Header() (metadata.MD, error) {
@@ -54,9 +66,7 @@ func (s *clientStream) Header() (metadata.MD, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get headers")
 	}
-	for k, v := range headers {
-		md.Append(k, v...)
-	}
+	appendHeader(md, headers)
 	s.headerMu.Lock()
 	s.headerMD = md
 	s.headerMu.Unlock()
@@ -69,9 +79,10 @@ func (s *clientStream) header() metadata.MD {
 	return s.headerMD
 }
 
+// Trailer returns nil if the trailer has not been received yet.
 func (s *clientStream) Trailer() metadata.MD {
 	if !s.closed.Load() {
-		panic("Trailer must be called after stream.CloseAndReceive has been called")
+		return nil
 	}
 	return s.trailer()
 }
@@ -82,35 +93,59 @@ func (s *clientStream) trailer() metadata.MD {
 	return s.trailerMD
 }
 
-func (s *clientStream) Send(ctx context.Context, req interface{}) error {
-	r, err := encodeRequestBody(s.callOptions.codec, req)
+// Send sends req to the server. ctx bounds only this call, the stream is governed by the context
+// passed at the creation.
+func (s *clientStream) Send(ctx context.Context, req interface{}) (err error) {
+	defer func() {
+		err = streamError(s.ctx, ctx, err)
+	}()
+
+	b, err := encodeRequestBody(s.callOptions, req)
 	if err != nil {
 		return errors.Wrap(err, "failed to build the request")
 	}
 
-	h := make(http.Header)
-	md, ok := metadata.FromOutgoingContext(ctx)
-	if ok {
-		for k, v := range md {
-			for _, vv := range v {
-				h.Add(k, vv)
-			}
-		}
+	s.headerOnce.Do(func() {
+		// The timeout is computed when the header frame is sent, not when the stream is created.
+		err = setTimeoutHeader(s.ctx, s.reqHeader)
+		s.transport.SetRequestHeader(s.reqHeader)
+	})
+	if err != nil {
+		return err
 	}
-	s.transport.SetRequestHeader(h)
 
-	if err := s.transport.Send(ctx, r); err != nil {
+	if err := s.transport.Send(ctx, bytes.NewReader(b)); err != nil {
 		return errors.Wrap(err, "failed to send the request")
 	}
 	return nil
 }
 
 func (s *clientStream) CloseAndReceive(ctx context.Context, res interface{}) error {
-	if err := s.transport.CloseSend(); err != nil {
-		return errors.Wrap(err, "failed to close the send stream")
+	if err := s.CloseSend(); err != nil {
+		return err
 	}
+	return s.Receive(ctx, res)
+}
 
+// CloseSend closes the send direction of the stream.
+func (s *clientStream) CloseSend() error {
+	if err := s.transport.CloseSend(); err != nil {
+		return toStatusError(errors.Wrap(err, "failed to close the send stream"))
+	}
 	s.closed.Store(true)
+	return nil
+}
+
+// Receive receives the response and the trailer. It must be called after CloseSend.
+// It returns io.EOF if the response has already been received.
+func (s *clientStream) Receive(ctx context.Context, res interface{}) (err error) {
+	if s.received.Swap(true) {
+		return io.EOF
+	}
+	defer func() {
+		err = streamError(s.ctx, ctx, err)
+		s.finish()
+	}()
 
 	rawBody, err := s.transport.Receive(ctx)
 	if s.isTrailerOnly(err) {
@@ -128,19 +163,24 @@ func (s *clientStream) CloseAndReceive(ctx context.Context, res interface{}) err
 		return statusFromHeader(trailer).Err()
 	}
 	if err != nil {
-		return errors.Wrap(err, "failed to receive the response")
+		return receiveError(err, "failed to receive the response")
 	}
-
 	var closeOnce sync.Once
 	defer closeOnce.Do(func() { rawBody.Close() })
 
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	dec := newDecoder(s.callOptions, rawBody)
+	f, err := dec.Decode()
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return receiveError(err, "failed to read the response frame")
 	}
 
-	if resHeader.IsMessageHeader() {
-		resBody, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+	header, err := s.Header()
+	if err != nil {
+		return errors.Wrap(err, "failed to get the response header")
+	}
+
+	if f.IsMessage() {
+		resBody, err := parseMessage(s.callOptions, f, header)
 		if err != nil {
 			return errors.Wrap(err, "failed to parse the response body")
 		}
@@ -149,26 +189,29 @@ func (s *clientStream) CloseAndReceive(ctx context.Context, res interface{}) err
 			return errors.Wrapf(err, "failed to unmarshal response body by codec %s", codec.Name())
 		}
 
-		closeOnce.Do(func() { rawBody.Close() })
+		f, err = dec.Decode()
+		if err == io.EOF {
+			closeOnce.Do(func() { rawBody.Close() })
 
-		// improbable-eng/grpc-web returns the trailer in another message.
-		rawBody2, err := s.transport.Receive(ctx)
-		if err != nil {
-			return errors.Wrap(err, "failed to receive the response trailer")
-		}
-		defer rawBody2.Close()
-		rawBody = rawBody2
+			// improbable-eng/grpc-web returns the trailer in another message.
+			var rawBody2 io.ReadCloser
+			rawBody2, err = s.transport.Receive(ctx)
+			if err != nil {
+				return receiveError(err, "failed to receive the response trailer")
+			}
+			defer rawBody2.Close()
 
-		resHeader, err = parser.ParseResponseHeader(rawBody2)
+			f, err = newDecoder(s.callOptions, rawBody2).Decode()
+		}
 		if err != nil {
-			return errors.Wrap(err, "failed to parse response header2")
+			return receiveError(err, "failed to read the trailer frame")
 		}
 	}
-	if !resHeader.IsTrailerHeader() {
+	if !f.IsTrailer() {
 		return errors.New("unexpected header")
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+	status, trailer, err := parseStatusAndTrailer(s.callOptions, f, header)
 	if err != nil {
 		return errors.Wrap(err, "failed to parse status and trailer")
 	}
@@ -178,6 +221,14 @@ func (s *clientStream) CloseAndReceive(ctx context.Context, res interface{}) err
 	return status.Err()
 }
 
+// finish releases the transport after the stream has been finished.
+func (s *clientStream) finish() {
+	s.finishOnce.Do(func() {
+		s.stopWatch()
+		s.transport.Close()
+	})
+}
+
 func (s *clientStream) isTrailerOnly(err error) bool {
 	return errors.Is(err, io.ErrUnexpectedEOF) && s.trailer().Len() == 0
 }
@@ -195,50 +246,105 @@ type ServerStream interface {
 }
 
 type serverStream struct {
+	// ctx is the context bound to the stream. cancel releases it after the stream is finished.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cc          *ClientConn
 	endpoint    string
 	transport   transport.UnaryTransport
 	resStream   io.ReadCloser
+	dec         *frame.Decoder
 	callOptions *callOptions
 
+	// reqHeader and reqBody are kept to send the request again when the RPC is retried.
+	reqHeader http.Header
+	reqBody   []byte
+	retry     *retryState
+
 	closed          bool
 	header, trailer metadata.MD
+
+	// received is true if at least one frame is received.
+	received bool
+	// committed is true if at least one message is received. The RPC is not retried after that.
+	committed bool
 }
 
 func (s *serverStream) Header() (metadata.MD, error) {
 	return s.header, nil
 }
 
+// Trailer returns nil if the trailer has not been received yet.
 func (s *serverStream) Trailer() metadata.MD {
 	if !s.closed {
-		panic("Trailer must be called after stream.CloseAndReceive has been called")
+		return nil
 	}
 	return s.trailer
 }
 
-func (s *serverStream) Send(ctx context.Context, req interface{}) error {
-	codec := s.callOptions.codec
+// CloseSend does nothing because server streaming RPCs have only one request.
+func (s *serverStream) CloseSend() error {
+	return nil
+}
 
-	r, err := encodeRequestBody(codec, req)
+// Send sends the request. The response stream is governed by the context passed at the creation,
+// so ctx is only checked before sending.
+func (s *serverStream) Send(ctx context.Context, req interface{}) (err error) {
+	defer func() {
+		err = streamError(s.ctx, ctx, err)
+		if err != nil {
+			s.cancel()
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.reqBody, err = encodeRequestBody(s.callOptions, req)
 	if err != nil {
 		return errors.Wrap(err, "failed to build the request body")
 	}
+	return s.send(s.ctx)
+}
+
+// send sends the request until it succeeds or the retry policy gives up.
+// Retry attempts use a new transport.
+func (s *serverStream) send(ctx context.Context) error {
+	for {
+		err := s.sendAttempt()
+		if err == nil || !s.retry.retry(ctx, err, nil) {
+			return err
+		}
+	}
+}
 
-	md, ok := metadata.FromOutgoingContext(ctx)
-	if ok {
-		for k, v := range md {
-			for _, vv := range v {
-				s.transport.Header().Add(k, vv)
-			}
+func (s *serverStream) sendAttempt() error {
+	if s.retry.attempts > 0 {
+		if s.resStream != nil {
+			s.resStream.Close()
+		}
+		s.transport.Close()
+		s.transport = s.cc.newUnaryTransport()
+		h := s.transport.Header()
+		for k, v := range s.reqHeader {
+			h[k] = v
 		}
+		h.Set("grpc-previous-rpc-attempts", strconv.Itoa(s.retry.attempts))
+	}
+	// The request is sent later than the creation of the stream, so the timeout is updated to the remaining time.
+	if err := setTimeoutHeader(s.ctx, s.transport.Header()); err != nil {
+		return err
 	}
 
-	contentType := "application/grpc-web+" + codec.Name()
-	header, rawBody, err := s.transport.Send(ctx, s.endpoint, contentType, r)
+	header, rawBody, err := s.transport.Send(s.ctx, s.endpoint, s.callOptions.contentType(), bytes.NewReader(s.reqBody))
 	if err != nil {
 		return errors.Wrap(err, "failed to send the request")
 	}
 	s.header = toMetadata(header)
 	s.resStream = rawBody
+	s.dec = newDecoder(s.callOptions, rawBody)
+	s.closed, s.received, s.trailer = false, false, nil
+	s.callOptions.setPeer(s.transport)
 	return nil
 }
 
@@ -247,30 +353,55 @@ func (s *serverStream) Receive(ctx context.Context, res interface{}) (err error)
 		return errors.New("Receive must be call after calling Send")
 	}
 	defer func() {
-		if err == io.EOF {
-			if rerr := s.transport.Close(); rerr != nil {
-				err = rerr
-			}
-			s.resStream.Close()
+		err = streamError(s.ctx, ctx, err)
+		if err == nil {
+			return
+		}
+		// The stream is finished, release the response body and its connection.
+		s.cancel()
+		s.resStream.Close()
+		if rerr := s.transport.Close(); rerr != nil && err == io.EOF {
+			err = rerr
 		}
 	}()
 
-	var h [5]byte
-	n, err := s.resStream.Read(h[:])
-	if err != nil {
-		return err
-	}
-	if n != len(h) {
-		return io.ErrUnexpectedEOF
+	for {
+		err := s.receive(ctx, res)
+		if err == nil || err == io.EOF || s.committed || !s.retry.retry(ctx, err, s.trailer) {
+			return err
+		}
+		if err := s.send(ctx); err != nil {
+			return err
+		}
 	}
+}
+
+func (s *serverStream) receive(ctx context.Context, res interface{}) error {
+	stop := watchContext(ctx, s.resStream)
+	defer stop()
 
-	flag := h[0]
-	length := binary.BigEndian.Uint32(h[1:])
-	if length == 0 {
+	f, err := s.dec.Decode()
+	if err == io.EOF && !s.received {
+		// Trailers-only responses, no message.
+		s.closed = true
+		s.trailer = s.header
+		if st := statusFromHeader(s.header); st.Code() != codes.OK {
+			return st.Err()
+		}
+		return io.EOF
+	}
+	if err == io.EOF {
 		return io.EOF
 	}
-	if flag == 0 || flag == 1 { // Message header.
-		msg, err := parser.ParseLengthPrefixedMessage(s.resStream, length)
+	if err != nil {
+		return receiveError(err, "failed to read the response frame")
+	}
+	s.received = true
+
+	switch {
+	case f.IsMessage():
+		s.committed = true
+		msg, err := parseMessage(s.callOptions, f, s.header)
 		if err != nil {
 			return err
 		}
@@ -278,9 +409,11 @@ func (s *serverStream) Receive(ctx context.Context, res interface{}) (err error)
 			return errors.Wrap(err, "failed to unmarshal response body")
 		}
 		return nil
+	case !f.IsTrailer():
+		return errors.New("unexpected header")
 	}
 
-	status, trailer, err := parser.ParseStatusAndTrailer(s.resStream, length)
+	status, trailer, err := parseStatusAndTrailer(s.callOptions, f, s.header)
 	if err != nil {
 		return errors.Wrap(err, "failed to parse trailer")
 	}
@@ -316,7 +449,15 @@ var (
 	gRPCStatusBytes          = []byte("grpc-status: ")
 )
 
-func (s *bidiStream) Receive(ctx context.Context, res interface{}) error {
+func (s *bidiStream) Receive(ctx context.Context, res interface{}) (err error) {
+	defer func() {
+		err = streamError(s.ctx, ctx, err)
+		if err != nil {
+			// The stream is finished by io.EOF or an error.
+			s.finish()
+		}
+	}()
+
 	if s.closed.Load() {
 		return io.EOF
 	}
@@ -342,17 +483,23 @@ func (s *bidiStream) Receive(ctx context.Context, res interface{}) error {
 		return statusFromHeader(trailer).Err()
 	}
 	if err != nil {
-		return errors.Wrap(err, "failed to receive the response")
+		return receiveError(err, "failed to receive the response")
+	}
+	defer rawBody.Close()
+
+	f, err := newDecoder(s.callOptions, rawBody).Decode()
+	if err != nil {
+		return receiveError(err, "failed to read the response frame")
 	}
 
-	resHeader, err := parser.ParseResponseHeader(rawBody)
+	header, err := s.Header()
 	if err != nil {
-		return errors.Wrap(err, "failed to parse response header")
+		return errors.Wrap(err, "failed to get the response header")
 	}
 
 	switch {
-	case resHeader.IsMessageHeader():
-		msg, err := parser.ParseLengthPrefixedMessage(rawBody, resHeader.ContentLength)
+	case f.IsMessage():
+		msg, err := parseMessage(s.callOptions, f, header)
 		if err != nil {
 			return err
 		}
@@ -360,10 +507,10 @@ func (s *bidiStream) Receive(ctx context.Context, res interface{}) error {
 			return errors.Wrap(err, "failed to unmarshal response body")
 		}
 		return nil
-	case resHeader.IsTrailerHeader():
+	case f.IsTrailer():
 		s.closed.Store(true)
 
-		status, trailer, err := parser.ParseStatusAndTrailer(rawBody, resHeader.ContentLength)
+		status, trailer, err := parseStatusAndTrailer(s.callOptions, f, header)
 		if err != nil {
 			return errors.Wrap(err, "failed to parse trailer")
 		}
@@ -382,7 +529,7 @@ func (s *bidiStream) Receive(ctx context.Context, res interface{}) error {
 
 func (s *bidiStream) CloseSend() error {
 	if err := s.transport.CloseSend(); err != nil {
-		return errors.Wrap(err, "failed to close the send stream")
+		return toStatusError(errors.Wrap(err, "failed to close the send stream"))
 	}
 	s.sentCloseSend.Store(true)
 	return nil
@@ -392,14 +539,37 @@ func (s *bidiStream) isTrailerOnly(err error) bool {
 	return s.sentCloseSend.Load() && s.clientStream.isTrailerOnly(err)
 }
 
+// streamError converts err to a status error. If err is caused by ctx of the call or streamCtx bound to the stream,
+// the code is codes.Canceled or codes.DeadlineExceeded.
+func streamError(streamCtx, ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		ctx = streamCtx
+	}
+	return toStatusError(contextError(ctx, err))
+}
+
+// statusFromHeader builds the status from the header of a trailers-only response.
+// Same as trailers, grpc-status-details-bin takes precedence over grpc-status and grpc-message.
 func statusFromHeader(h metadata.MD) *status.Status {
-	msgs, codeStr := h.Get("grpc-message"), h.Get("grpc-status")
+	codeStr := h.Get("grpc-status")
 	if len(codeStr) == 0 {
 		return status.New(codes.Unknown, "response closed without grpc-status (headers only)")
 	}
+	if v := h.Get("grpc-status-details-bin"); len(v) != 0 {
+		// h is converted by toMetadata, so the value has already been decoded.
+		st := &spb.Status{}
+		if err := proto.Unmarshal([]byte(v[0]), st); err != nil {
+			return status.Newf(codes.Internal, "transport: malformed grpc-status-details-bin: %v", err)
+		}
+		return status.FromProto(st)
+	}
 	i, err := strconv.Atoi(codeStr[0])
 	if err != nil {
 		return status.New(codes.Unknown, err.Error())
 	}
-	return status.New(codes.Code(i), msgs[0])
+	var msg string
+	if v := h.Get("grpc-message"); len(v) != 0 {
+		msg = parser.DecodeGRPCMessage(v[0])
+	}
+	return status.New(codes.Code(i), msg)
 }
@@ -0,0 +1,126 @@
+package grpcweb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tlsOptions is the TLS settings specified by WithClientCertificateFiles, WithRootCAs and WithPinnedPublicKeys.
+type tlsOptions struct {
+	certFile, keyFile string
+	rootCAs           *x509.CertPool
+	pins              []string
+}
+
+func (o *tlsOptions) enabled() bool {
+	return o.certFile != "" || o.rootCAs != nil || len(o.pins) != 0
+}
+
+// config builds a TLS config for both transports from base, which may be nil.
+// The transports negotiate the application protocol, so NextProtos is not set here.
+func (o *tlsOptions) config(base *tls.Config) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if o.rootCAs != nil {
+		cfg.RootCAs = o.rootCAs
+	}
+	if o.certFile != "" {
+		r, err := newCertificateReloader(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = r.getClientCertificate
+	}
+	if len(o.pins) != 0 {
+		pins := make([][]byte, 0, len(o.pins))
+		for _, p := range o.pins {
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil || len(b) != sha256.Size {
+				return nil, errors.Errorf("invalid pin %q, it must be a base64-encoded SHA-256 hash", p)
+			}
+			pins = append(pins, b)
+		}
+		cfg.VerifyPeerCertificate = verifyPins(pins)
+	}
+	return cfg, nil
+}
+
+// verifyPins returns a function which verifies that one of the certificates in the verified chains
+// has a public key which matches one of pins.
+func verifyPins(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, p := range pins {
+					if bytes.Equal(h[:], p) {
+						return nil
+					}
+				}
+			}
+		}
+		return errors.New("tls: no public key of the server certificate chain matches the pinned public keys")
+	}
+}
+
+// certificateReloader loads the client certificate from files, and reloads it if the files are modified.
+type certificateReloader struct {
+	certFile, keyFile string
+
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// If reloading fails, for example the files are being written, the previous certificate is used.
+	r.reload()
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files are modified since the last load.
+// r.mu must be held except in newCertificateReloader.
+func (r *certificateReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat the client certificate")
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat the client key")
+	}
+	if r.cert != nil &&
+		certInfo.ModTime().Equal(r.certMod) && certInfo.Size() == r.certSize &&
+		keyInfo.ModTime().Equal(r.keyMod) && keyInfo.Size() == r.keySize {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load the client certificate")
+	}
+	r.cert = &cert
+	r.certMod, r.certSize = certInfo.ModTime(), certInfo.Size()
+	r.keyMod, r.keySize = keyInfo.ModTime(), keyInfo.Size()
+	return nil
+}
@@ -0,0 +1,331 @@
+package grpcweb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ktr0731/grpc-test/api"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// testCA issues certificates for the local TLS server and clients.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey should not return an error, but got '%s'", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate should not return an error, but got '%s'", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate should not return an error, but got '%s'", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns a PEM encoded certificate and key for cn.
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey should not return an error, but got '%s'", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate should not return an error, but got '%s'", err)
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey should not return an error, but got '%s'", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+// writeClientCertificate writes the client certificate for cn to dir, and returns the paths.
+func (ca *testCA) writeClientCertificate(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	certPEM, keyPEM := ca.issue(t, cn, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("WriteFile should not return an error, but got '%s'", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("WriteFile should not return an error, but got '%s'", err)
+	}
+	return certFile, keyFile
+}
+
+// newMTLSServer starts a gRPC-Web server which requires client certificates issued by ca.
+// If h2 is true, the server prefers HTTP/2.
+// It returns a function that reports the common name of the last client.
+func newMTLSServer(t *testing.T, ca *testCA, h2 bool) (*httptest.Server, func() string) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "response.in"))
+	if err != nil {
+		t.Fatalf("ReadFile should not return an error, but got '%s'", err)
+	}
+	var cn atomic.String
+	upgrader := websocket.Upgrader{Subprotocols: []string{"grpc-websockets"}}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade should not return an error, but got '%s'", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		// Close the connection to perform the handshake for each request.
+		w.Header().Set("connection", "close")
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write(b)
+	}))
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair should not return an error, but got '%s'", err)
+	}
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	}
+	srv.EnableHTTP2 = h2
+	srv.StartTLS()
+	return srv, cn.Load
+}
+
+func TestTLSOptions(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	srv, _ := newMTLSServer(t, ca, false)
+	defer srv.Close()
+	h2Server, _ := newMTLSServer(t, ca, true)
+	defer h2Server.Close()
+
+	dir, err := ioutil.TempDir("", "grpcweb")
+	if err != nil {
+		t.Fatalf("TempDir should not return an error, but got '%s'", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := ca.writeClientCertificate(t, dir, "client")
+
+	// ca.cert is in the verified chain.
+	caPin := sha256.Sum256(ca.cert.RawSubjectPublicKeyInfo)
+	otherPin := sha256.Sum256([]byte("other"))
+
+	cases := map[string]struct {
+		h2           bool
+		opts         []DialOption
+		expectedCode codes.Code
+		expectedMsg  string
+	}{
+		"mutual TLS": {
+			opts: []DialOption{WithRootCAs(ca.pool()), WithClientCertificateFiles(certFile, keyFile)},
+		},
+		"HTTP/2 server": {
+			h2:   true,
+			opts: []DialOption{WithRootCAs(ca.pool()), WithClientCertificateFiles(certFile, keyFile)},
+		},
+		"with TLS config": {
+			h2:   true,
+			opts: []DialOption{WithTLSConfig(&tls.Config{RootCAs: ca.pool()}), WithClientCertificateFiles(certFile, keyFile)},
+		},
+		"pinned public key": {
+			opts: []DialOption{
+				WithRootCAs(ca.pool()),
+				WithClientCertificateFiles(certFile, keyFile),
+				WithPinnedPublicKeys(base64.StdEncoding.EncodeToString(otherPin[:]), base64.StdEncoding.EncodeToString(caPin[:])),
+			},
+		},
+		"pin mismatch": {
+			opts: []DialOption{
+				WithRootCAs(ca.pool()),
+				WithClientCertificateFiles(certFile, keyFile),
+				WithPinnedPublicKeys(base64.StdEncoding.EncodeToString(otherPin[:])),
+			},
+			expectedCode: codes.Unavailable,
+			expectedMsg:  "pinned public keys",
+		},
+		"unknown authority": {
+			opts:         []DialOption{WithClientCertificateFiles(certFile, keyFile)},
+			expectedCode: codes.Unavailable,
+			expectedMsg:  "certificate",
+		},
+		"no client certificate": {
+			opts:         []DialOption{WithRootCAs(ca.pool())},
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	ctx := context.Background()
+	for name, c := range cases {
+		c := c
+		addr := srv.Listener.Addr().String()
+		if c.h2 {
+			addr = h2Server.Listener.Addr().String()
+		}
+		assertErr := func(t *testing.T, err error) {
+			t.Helper()
+			if c.expectedCode == codes.OK {
+				if err != nil {
+					t.Fatalf("should not return an error, but got '%s'", err)
+				}
+				return
+			}
+			st := status.Convert(err)
+			if st.Code() != c.expectedCode {
+				t.Errorf("expected status code: %s, but got %s ('%v')", c.expectedCode, st.Code(), err)
+			}
+			if !strings.Contains(st.Message(), c.expectedMsg) {
+				t.Errorf("the message should contain '%s', but got '%s'", c.expectedMsg, st.Message())
+			}
+		}
+
+		t.Run("unary/"+name, func(t *testing.T) {
+			client, err := DialContext(addr, c.opts...)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+			defer client.Close()
+
+			var res api.SimpleResponse
+			assertErr(t, client.Invoke(ctx, "/service/Method", &api.SimpleRequest{Name: "nano"}, &res))
+		})
+
+		t.Run("WebSocket/"+name, func(t *testing.T) {
+			client, err := DialContext(addr, c.opts...)
+			if err != nil {
+				t.Fatalf("DialContext should not return an error, but got '%s'", err)
+			}
+			defer client.Close()
+
+			stm, err := client.NewBidiStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/service/Method")
+			assertErr(t, err)
+			if err == nil {
+				stm.CloseSend()
+			}
+		})
+	}
+}
+
+func TestTLSOptionsError(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][]DialOption{
+		"invalid pin":                        {WithPinnedPublicKeys("invalid")},
+		"missing certificate files":          {WithClientCertificateFiles("missing.crt", "missing.key")},
+		"used with WithInsecure":             {WithRootCAs(x509.NewCertPool()), WithInsecure()},
+		"used with WithTransportCredentials": {WithRootCAs(x509.NewCertPool()), WithTransportCredentials(credentials.NewTLS(nil))},
+	}
+	for name, opts := range cases {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			if _, err := DialContext(":50051", opts...); err == nil {
+				t.Errorf("DialContext should return an error, but got nil")
+			}
+		})
+	}
+}
+
+func TestClientCertificateReload(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	srv, lastCN := newMTLSServer(t, ca, false)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "grpcweb")
+	if err != nil {
+		t.Fatalf("TempDir should not return an error, but got '%s'", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := ca.writeClientCertificate(t, dir, "before")
+
+	client, err := DialContext(srv.Listener.Addr().String(), WithRootCAs(ca.pool()), WithClientCertificateFiles(certFile, keyFile))
+	if err != nil {
+		t.Fatalf("DialContext should not return an error, but got '%s'", err)
+	}
+	defer client.Close()
+
+	invoke := func() {
+		var res api.SimpleResponse
+		if err := client.Invoke(context.Background(), "/service/Method", &api.SimpleRequest{Name: "nano"}, &res); err != nil {
+			t.Fatalf("Invoke should not return an error, but got '%s'", err)
+		}
+	}
+
+	invoke()
+	if cn := lastCN(); cn != "before" {
+		t.Errorf("expected common name is 'before', but got '%s'", cn)
+	}
+
+	ca.writeClientCertificate(t, dir, "after")
+	// Make sure that the modification time is changed even if the file system has a coarse resolution.
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatalf("Chtimes should not return an error, but got '%s'", err)
+		}
+	}
+
+	invoke()
+	if cn := lastCN(); cn != "after" {
+		t.Errorf("expected common name is 'after', but got '%s'", cn)
+	}
+}
@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// rawConn hides all methods except net.Conn's ones from the underlying connection.
+// credentials.TransportCredentials wraps the handshaked connection if the raw connection
+// implements syscall.Conn, but net/http needs *tls.Conn as it is to negotiate HTTP/2.
+type rawConn struct {
+	net.Conn
+}
+
+// dial dials to addr by ContextDialer if it is specified.
+func (o *ConnectOptions) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o != nil && o.ContextDialer != nil {
+		return o.ContextDialer(ctx, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// dialTLS dials to addr and performs the TLS handshake by TransportCredentials.
+func (o *ConnectOptions) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := o.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, _, err := o.TransportCredentials.ClientHandshake(ctx, addr, &rawConn{conn})
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to perform the TLS handshake")
+	}
+	return tlsConn, nil
+}
+
+// NewHTTPClient returns a new HTTP client configured by opts.
+// Unlike http.DefaultClient, the client has its own connection pool, so
+// the caller should call CloseIdleConnections when it is no longer used.
+// opts.HTTPClient is ignored.
+func NewHTTPClient(opts *ConnectOptions) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if opts != nil && opts.ContextDialer != nil {
+		tr.DialContext = opts.dial
+	}
+	switch {
+	case opts.useTLSConfig():
+		tr.TLSClientConfig = opts.TLSConfig.Clone()
+	case opts.useTransportCredentials():
+		// The TLS handshake is performed in DialTLSContext, so it cannot go through proxies.
+		// Otherwise, net/http performs its own handshake over the tunnel without the credentials.
+		tr.Proxy = nil
+		tr.DialTLSContext = opts.dialTLS
+	}
+	return &http.Client{Transport: tr}
+}
+
+func newWebSocketDialer(opts *ConnectOptions) *websocket.Dialer {
+	if opts == nil {
+		return websocket.DefaultDialer
+	}
+	if opts.WebSocketDialer != nil {
+		return opts.WebSocketDialer
+	}
+	useCreds := opts.useTransportCredentials()
+	if opts.ContextDialer == nil && !useCreds && !opts.useTLSConfig() {
+		return websocket.DefaultDialer
+	}
+
+	d := *websocket.DefaultDialer
+	if opts.ContextDialer != nil {
+		d.NetDialContext = opts.dial
+	}
+	if opts.useTLSConfig() {
+		// WebSocket handshakes require HTTP/1.1, so HTTP/2 must not be offered.
+		cfg := opts.TLSConfig.Clone()
+		cfg.NextProtos = []string{"http/1.1"}
+		d.TLSClientConfig = cfg
+	}
+	if useCreds {
+		// The TLS handshake is performed in NetDialTLSContext, so it cannot go through proxies.
+		d.Proxy = nil
+		d.NetDialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := opts.dialTLS(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			// WebSocket handshakes require HTTP/1.1.
+			if c, ok := conn.(*tls.Conn); ok && c.ConnectionState().NegotiatedProtocol == "h2" {
+				conn.Close()
+				return nil, errors.New("the server negotiated HTTP/2, but WebSocket requires HTTP/1.1. TransportCredentials always offer HTTP/2, use TLSConfig instead")
+			}
+			return conn, nil
+		}
+	}
+	return &d
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error is a failure of the transport layer classified into a gRPC status code.
+// For example, connection failures are codes.Unavailable.
+// status.FromError converts it to a status, and errors.Unwrap returns the cause.
+type Error struct {
+	Code codes.Code
+	Msg  string
+	Err  error
+}
+
+func newError(code codes.Code, err error, msg string) *Error {
+	return &Error{Code: code, Msg: msg, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the status which has Code and the message of e.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(e.Code, e.Error())
+}
+
+// maxErrorBodySize is the maximum length of the response body kept in HTTPStatusError.
+const maxErrorBodySize = 512
+
+// HTTPStatusError is returned if the server responds with a non-200 HTTP status or
+// a content-type other than gRPC-Web. It is typically caused by proxies or load balancers.
+// status.FromError converts it to a status following the HTTP to gRPC status code mapping.
+//
+// spec: https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+type HTTPStatusError struct {
+	StatusCode  int
+	ContentType string
+	// Body is the beginning of the response body. It is truncated to 512 bytes.
+	Body []byte
+}
+
+func newHTTPStatusError(res *http.Response) *HTTPStatusError {
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body.Close()
+	return &HTTPStatusError{
+		StatusCode:  res.StatusCode,
+		ContentType: res.Header.Get("content-type"),
+		Body:        b,
+	}
+}
+
+func (e *HTTPStatusError) Error() string {
+	var msg string
+	if e.StatusCode != http.StatusOK {
+		msg = fmt.Sprintf("unexpected HTTP status code received from server: %d (%s)", e.StatusCode, http.StatusText(e.StatusCode))
+	} else {
+		msg = fmt.Sprintf("received unexpected content-type %q", e.ContentType)
+	}
+	if len(e.Body) != 0 {
+		msg += fmt.Sprintf("; body: %q", e.Body)
+	}
+	return msg
+}
+
+// GRPCStatus returns the status converted from the HTTP status.
+func (e *HTTPStatusError) GRPCStatus() *status.Status {
+	return status.New(httpStatusToCode(e.StatusCode), e.Error())
+}
+
+// httpStatusToCode is same as HTTPStatusConvTab in grpc/grpc-go.
+func httpStatusToCode(s int) codes.Code {
+	switch s {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
+
+// isGRPCResponse returns true if res is a response of gRPC-Web servers, which has the content-type
+// application/grpc-web or application/grpc-web-text with an optional subtype.
+// Trailers-only responses which have grpc-status are also regarded as gRPC-Web responses.
+func isGRPCResponse(res *http.Response) bool {
+	if res.Header.Get("grpc-status") != "" {
+		return true
+	}
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+	ct := strings.ToLower(res.Header.Get("content-type"))
+	if i := strings.IndexByte(ct, ';'); i != -1 {
+		ct = ct[:i]
+	}
+	ct = strings.TrimSpace(ct)
+	for _, t := range []string{"application/grpc-web", "application/grpc-web-text"} {
+		if ct == t || strings.HasPrefix(ct, t+"+") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,3 +1,45 @@
 package transport
 
-type ConnectOptions struct{}
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/credentials"
+)
+
+type ConnectOptions struct {
+	// Insecure disables transport security. If it is true, transports use
+	// plain HTTP and WebSocket.
+	Insecure bool
+	// TransportCredentials is used to establish TLS connections.
+	// If it is nil and Insecure is false, transports use TLS with the system default settings.
+	TransportCredentials credentials.TransportCredentials
+	// TLSConfig is used to establish TLS connections instead of TransportCredentials.
+	// Unlike TransportCredentials, which always offer HTTP/2, the WebSocket transport
+	// negotiates HTTP/1.1 with it, so both transports work against HTTP/2-capable hosts.
+	TLSConfig *tls.Config
+
+	// HTTPClient is used by the HTTP transport as it is.
+	// If it is specified, TransportCredentials, TLSConfig and ContextDialer are not applied to the HTTP transport.
+	HTTPClient *http.Client
+	// WebSocketDialer is used by the WebSocket transport as it is.
+	// If it is specified, TransportCredentials, TLSConfig and ContextDialer are not applied to the WebSocket transport.
+	WebSocketDialer *websocket.Dialer
+	// ContextDialer creates network connections for both transports.
+	ContextDialer func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+func (o *ConnectOptions) insecure() bool {
+	return o != nil && o.Insecure
+}
+
+func (o *ConnectOptions) useTransportCredentials() bool {
+	return o != nil && !o.Insecure && o.TLSConfig == nil && o.TransportCredentials != nil
+}
+
+func (o *ConnectOptions) useTLSConfig() bool {
+	return o != nil && !o.Insecure && o.TLSConfig != nil
+}
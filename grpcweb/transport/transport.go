@@ -4,16 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 )
 
 type UnaryTransport interface {
@@ -27,7 +34,11 @@ type httpTransport struct {
 	client *http.Client
 	opts   *ConnectOptions
 
+	// ownClient is true if client is created by the transport itself.
+	ownClient bool
+
 	header http.Header
+	peer   peer.Peer
 
 	sent bool
 }
@@ -44,39 +55,126 @@ func (t *httpTransport) Send(ctx context.Context, endpoint, contentType string,
 		t.sent = true
 	}()
 
-	// TODO: HTTPS support.
-	scheme := "http"
+	scheme := "https"
+	if t.opts.insecure() {
+		scheme = "http"
+	}
 	u := url.URL{Scheme: scheme, Host: t.host, Path: endpoint}
 	url := u.String()
 	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to build the API request")
+		return nil, nil, newError(codes.Internal, err, "failed to build the API request")
 	}
 
 	req.Header = t.Header()
 	req.Header.Add("content-type", contentType)
 	req.Header.Add("x-grpc-web", "1")
 
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.peer.Addr = info.Conn.RemoteAddr()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
 	res, err := t.client.Do(req)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to send the API")
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, newError(codes.Unavailable, err, "failed to send the API")
+	}
+	if res.TLS != nil {
+		t.peer.AuthInfo = credentials.TLSInfo{State: *res.TLS}
+	}
+	if !isGRPCResponse(res) {
+		return nil, nil, newHTTPStatusError(res)
+	}
+
+	return res.Header, newContextBody(ctx, res.Body), nil
+}
+
+// contextBody is a response body which is closed when ctx is done.
+// It aborts blocked reads even if the underlying connection doesn't respect ctx.
+type contextBody struct {
+	io.ReadCloser
+	ctx context.Context
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newContextBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	b := &contextBody{ReadCloser: body, ctx: ctx, done: make(chan struct{})}
+	if ctx.Done() == nil {
+		return b
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			body.Close()
+		case <-b.done:
+		}
+	}()
+	return b
+}
+
+// Read returns ctx.Err() if the read is failed because of ctx.
+// Other failures except io.EOF and io.ErrUnexpectedEOF are regarded as connection errors.
+func (b *contextBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == nil, err == io.EOF:
+		return n, err
+	case b.ctx.Err() != nil:
+		return n, b.ctx.Err()
+	case err == io.ErrUnexpectedEOF:
+		return n, err
 	}
+	return n, newError(codes.Unavailable, err, "failed to read the response body")
+}
 
-	return res.Header, res.Body, nil
+func (b *contextBody) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return b.ReadCloser.Close()
 }
 
+// Peer returns the peer of the connection used by Send.
+func (t *httpTransport) Peer() *peer.Peer {
+	return &t.peer
+}
+
+// Close releases connections only if the client is owned by the transport.
+// Shared clients keep their connections alive for subsequent requests.
 func (t *httpTransport) Close() error {
-	t.client.CloseIdleConnections()
+	if t.ownClient {
+		t.client.CloseIdleConnections()
+	}
 	return nil
 }
 
-var NewUnary = func(host string, opts *ConnectOptions) UnaryTransport {
-	return &httpTransport{
+// UnaryTransportFactory creates a new UnaryTransport for each request.
+type UnaryTransportFactory func(host string, opts *ConnectOptions) UnaryTransport
+
+// NewUnary is the default UnaryTransportFactory.
+// It uses opts.HTTPClient if specified. Otherwise, it creates a new client only for the transport
+// if opts requires customized connections, or uses http.DefaultClient.
+var NewUnary UnaryTransportFactory = func(host string, opts *ConnectOptions) UnaryTransport {
+	t := &httpTransport{
 		host:   host,
 		client: http.DefaultClient,
 		opts:   opts,
 		header: make(http.Header),
 	}
+	switch {
+	case opts == nil:
+	case opts.HTTPClient != nil:
+		t.client = opts.HTTPClient
+	case opts.ContextDialer != nil || opts.useTransportCredentials() || opts.useTLSConfig():
+		t.client = NewHTTPClient(opts)
+		t.ownClient = true
+	}
+	return t
 }
 
 type ClientStreamTransport interface {
@@ -111,7 +209,7 @@ type webSocketTransport struct {
 	once    sync.Once
 	resOnce sync.Once
 
-	closed bool
+	closed atomic.Bool
 
 	writeMu sync.Mutex
 
@@ -130,50 +228,68 @@ func (t *webSocketTransport) SetRequestHeader(h http.Header) {
 	t.reqHeader = h
 }
 
-func (t *webSocketTransport) Send(ctx context.Context, body io.Reader) error {
-	if t.closed {
+func (t *webSocketTransport) Send(ctx context.Context, body io.Reader) (err error) {
+	if t.closed.Load() {
 		return io.EOF
 	}
+	if err := ctx.Err(); err != nil {
+		t.Abort(err)
+		return err
+	}
+
+	stop := t.watchContext(ctx)
+	defer func() {
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
 
-	var err error
 	t.once.Do(func() {
 		h := t.reqHeader
 		if h == nil {
 			h = make(http.Header)
 		}
-		h.Set("content-type", "application/grpc-web+proto")
+		if h.Get("content-type") == "" {
+			h.Set("content-type", "application/grpc-web+proto")
+		}
 		h.Set("x-grpc-web", "1")
 		var b bytes.Buffer
 		h.Write(&b)
 
-		t.writeMessage(websocket.BinaryMessage, b.Bytes())
+		err = t.writeMessage(websocket.BinaryMessage, b.Bytes())
 	})
 	if err != nil {
-		return err
+		return newError(codes.Unavailable, err, "failed to send the request header")
 	}
 
 	var b bytes.Buffer
 	b.Write([]byte{0x00})
 	_, err = io.Copy(&b, body)
 	if err != nil {
-		return errors.Wrap(err, "failed to read request body")
+		return newError(codes.Internal, err, "failed to read request body")
 	}
 
-	return t.writeMessage(websocket.BinaryMessage, b.Bytes())
+	if err := t.writeMessage(websocket.BinaryMessage, b.Bytes()); err != nil {
+		return newError(codes.Unavailable, err, "failed to send the request")
+	}
+	return nil
 }
 
-func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err error) {
-	if t.closed {
+func (t *webSocketTransport) Receive(ctx context.Context) (_ io.ReadCloser, err error) {
+	if t.closed.Load() {
 		return nil, io.EOF
 	}
+	if err := ctx.Err(); err != nil {
+		t.Abort(err)
+		return nil, err
+	}
 
+	stop := t.watchContext(ctx)
 	defer func() {
-		if err == nil {
-			return
-		}
-
-		if berr, ok := errors.Cause(err).(*net.OpError); ok && !berr.Temporary() {
-			err = io.EOF
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
 		}
 	}()
 
@@ -181,13 +297,14 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 	t.resOnce.Do(func() {
 		_, _, err = t.conn.NextReader()
 		if err != nil {
-			err = errors.Wrap(err, "failed to read response header")
+			err = newError(codes.Unavailable, err, "failed to read response header")
 			return
 		}
 
-		_, msg, err := t.conn.NextReader()
+		var msg io.Reader
+		_, msg, err = t.conn.NextReader()
 		if err != nil {
-			err = errors.Wrap(err, "failed to read response header")
+			err = newError(codes.Unavailable, err, "failed to read response header")
 			return
 		}
 
@@ -204,6 +321,9 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 		}
 		t.header = h
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
 	var b []byte
@@ -218,7 +338,7 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 				return nil, io.ErrUnexpectedEOF
 			}
 		}
-		err = errors.Wrap(err, "failed to read response body")
+		err = newError(codes.Unavailable, err, "failed to read response body")
 		return
 	}
 	buf.Write(b)
@@ -226,6 +346,7 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 	var r io.Reader
 	_, r, err = t.conn.NextReader()
 	if err != nil {
+		err = newError(codes.Unavailable, err, "failed to read response body")
 		return
 	}
 
@@ -233,7 +354,7 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 
 	by, err := ioutil.ReadAll(res)
 	if err != nil {
-		panic(err)
+		return nil, newError(codes.Unavailable, err, "failed to read response body")
 	}
 
 	res = ioutil.NopCloser(bytes.NewReader(by))
@@ -241,6 +362,21 @@ func (t *webSocketTransport) Receive(context.Context) (_ io.ReadCloser, err erro
 	return res, nil
 }
 
+// Peer returns the peer of the WebSocket connection.
+func (t *webSocketTransport) Peer() *peer.Peer {
+	p := &peer.Peer{Addr: t.conn.RemoteAddr()}
+	if c, ok := t.conn.UnderlyingConn().(*tls.Conn); ok {
+		p.AuthInfo = credentials.TLSInfo{State: c.ConnectionState()}
+	}
+	return p
+}
+
+// SetReadLimit sets the maximum size in bytes of a message read from the WebSocket connection.
+// Receive returns an error wrapping websocket.ErrReadLimit if a message exceeds the limit.
+func (t *webSocketTransport) SetReadLimit(limit int64) {
+	t.conn.SetReadLimit(limit)
+}
+
 func (t *webSocketTransport) CloseSend() error {
 	// 0x01 means the finish send frame.
 	// ref. transports/websocket/websocket.ts
@@ -249,31 +385,90 @@ func (t *webSocketTransport) CloseSend() error {
 }
 
 func (t *webSocketTransport) Close() error {
+	if t.closed.Swap(true) {
+		return nil
+	}
 	// Send the close message.
 	err := t.writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 	if err != nil {
+		t.conn.Close()
 		return err
 	}
-	t.closed = true
 	// Close the WebSocket connection.
 	return t.conn.Close()
 }
 
+// watchContext closes the connection when ctx is done, which aborts blocked reads and writes.
+// Before closing, it sends a close frame to notify the server of the cancellation.
+// The returned function stops watching.
+func (t *webSocketTransport) watchContext(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		t.Abort(ctx.Err())
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// Abort sends a close frame with the reason err and closes the connection.
+// Unlike Close, it doesn't wait for pending writes, so it tears down the transport even if Send is blocked.
+func (t *webSocketTransport) Abort(err error) {
+	if t.closed.Swap(true) {
+		return
+	}
+	// WriteControl can be called concurrently with writeMessage.
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error())
+	t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+	t.conn.Close()
+}
+
 func (t *webSocketTransport) writeMessage(msg int, b []byte) error {
 	t.writeMu.Lock()
 	defer t.writeMu.Unlock()
 	return t.conn.WriteMessage(msg, b)
 }
 
-var NewClientStream = func(host, endpoint string) (ClientStreamTransport, error) {
-	// TODO: WebSocket over TLS support.
-	u := url.URL{Scheme: "ws", Host: host, Path: endpoint}
+// closeTimeout is the time limit to send the close frame when the context is done.
+const closeTimeout = time.Second
+
+// ClientStreamTransportFactory creates a new ClientStreamTransport for each stream.
+// ctx governs only the creation, for example the WebSocket handshake.
+type ClientStreamTransportFactory func(ctx context.Context, host, endpoint string, opts *ConnectOptions) (ClientStreamTransport, error)
+
+// NewClientStream is the default ClientStreamTransportFactory.
+var NewClientStream ClientStreamTransportFactory = func(ctx context.Context, host, endpoint string, opts *ConnectOptions) (ClientStreamTransport, error) {
+	scheme := "wss"
+	if opts.insecure() {
+		scheme = "ws"
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: endpoint}
 	h := http.Header{}
 	h.Set("Sec-WebSocket-Protocol", "grpc-websockets")
 	var conn *websocket.Conn
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), h)
+	conn, res, err := newWebSocketDialer(opts).DialContext(ctx, u.String(), h)
+	if err == websocket.ErrBadHandshake && res != nil {
+		return nil, newHTTPStatusError(res)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to dial to '%s'", u.String())
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			// The handshake may time out by the deadline of ctx just before ctx is done.
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newError(codes.Unavailable, err, fmt.Sprintf("failed to dial to '%s'", u.String()))
 	}
 
 	return &webSocketTransport{
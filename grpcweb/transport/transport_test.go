@@ -0,0 +1,701 @@
+package transport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/internal/wstest"
+	"github.com/ktr0731/grpc-web-go-client/grpcweb/transport"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnary(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/service/Method" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write([]byte(r.Proto))
+	})
+
+	newTLSServer := func(h2 bool) *httptest.Server {
+		srv := httptest.NewUnstartedServer(handler)
+		srv.EnableHTTP2 = h2
+		srv.StartTLS()
+		return srv
+	}
+	h1Server, h2Server := newTLSServer(false), newTLSServer(true)
+	defer h1Server.Close()
+	defer h2Server.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	newTLSConfig := func(srv *httptest.Server, serverName string) *tls.Config {
+		pool := x509.NewCertPool()
+		pool.AddCert(srv.Certificate())
+		return &tls.Config{
+			RootCAs:    pool,
+			ServerName: serverName,
+		}
+	}
+	newCreds := func(srv *httptest.Server, serverName string) credentials.TransportCredentials {
+		cfg := newTLSConfig(srv, serverName)
+		cfg.NextProtos = []string{"http/1.1"}
+		return credentials.NewTLS(cfg)
+	}
+
+	cases := map[string]struct {
+		srv           *httptest.Server
+		opts          *transport.ConnectOptions
+		expectedProto string
+		wantErr       bool
+	}{
+		"TLS": {
+			srv: h1Server,
+			opts: &transport.ConnectOptions{
+				// httptest's certificate is valid for example.com.
+				TransportCredentials: newCreds(h1Server, "example.com"),
+			},
+			expectedProto: "HTTP/1.1",
+		},
+		"TLS with HTTP/2": {
+			srv: h2Server,
+			opts: &transport.ConnectOptions{
+				TransportCredentials: newCreds(h2Server, "example.com"),
+			},
+			expectedProto: "HTTP/2.0",
+		},
+		"TLS config with HTTP/2": {
+			srv: h2Server,
+			opts: &transport.ConnectOptions{
+				TLSConfig: newTLSConfig(h2Server, "example.com"),
+			},
+			expectedProto: "HTTP/2.0",
+		},
+		"server name mismatch": {
+			srv: h1Server,
+			opts: &transport.ConnectOptions{
+				TransportCredentials: newCreds(h1Server, "example.org"),
+			},
+			wantErr: true,
+		},
+		"unknown authority": {
+			srv:     h1Server,
+			opts:    &transport.ConnectOptions{},
+			wantErr: true,
+		},
+		"insecure": {
+			srv:           plainServer,
+			opts:          &transport.ConnectOptions{Insecure: true},
+			expectedProto: "HTTP/1.1",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			host := c.srv.Listener.Addr().String()
+			tr := transport.NewUnary(host, c.opts)
+			defer tr.Close()
+
+			_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("should return an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			defer body.Close()
+			b, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatalf("ReadAll should not return an error, but got '%s'", err)
+			}
+			if string(b) != c.expectedProto {
+				t.Errorf("expected protocol is '%s', but got '%s'", c.expectedProto, b)
+			}
+
+			p := tr.(interface{ Peer() *peer.Peer }).Peer()
+			if p.Addr.String() != host {
+				t.Errorf("expected peer address is '%s', but got '%s'", host, p.Addr)
+			}
+			if _, ok := p.AuthInfo.(credentials.TLSInfo); ok == c.opts.Insecure {
+				t.Errorf("unexpected auth info: %v", p.AuthInfo)
+			}
+		})
+	}
+}
+
+func TestUnaryCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/grpc-web+proto")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cases := map[string]struct {
+		newContext  func() (context.Context, context.CancelFunc)
+		expectedErr error
+	}{
+		"canceled": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expectedErr: context.Canceled,
+		},
+		"deadline exceeded": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			tr := transport.NewUnary(srv.Listener.Addr().String(), &transport.ConnectOptions{Insecure: true})
+			defer tr.Close()
+			_, body, err := tr.Send(ctx, "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+			if err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			defer body.Close()
+
+			b := make([]byte, len("partial"))
+			if _, err := io.ReadFull(body, b); err != nil {
+				t.Fatalf("ReadFull should not return an error, but got '%s'", err)
+			}
+			// The server never finishes the response, so the read is blocked until ctx is done.
+			if _, err := body.Read(b); err != c.expectedErr {
+				t.Errorf("expected error is '%s', but got '%v'", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	received := make(chan string, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		// Skip the header frame.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Errorf("ReadMessage should not return an error, but got '%s'", err)
+			return
+		}
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Errorf("ReadMessage should not return an error, but got '%s'", err)
+			return
+		}
+		received <- string(b[1:])
+	})
+
+	newTLSServer := func(h2 bool) *httptest.Server {
+		srv := httptest.NewUnstartedServer(handler)
+		srv.EnableHTTP2 = h2
+		srv.StartTLS()
+		return srv
+	}
+	h1Server, h2Server := newTLSServer(false), newTLSServer(true)
+	defer h1Server.Close()
+	defer h2Server.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	newTLSConfig := func(srv *httptest.Server) *tls.Config {
+		pool := x509.NewCertPool()
+		pool.AddCert(srv.Certificate())
+		return &tls.Config{
+			RootCAs:    pool,
+			ServerName: "example.com",
+		}
+	}
+	newCreds := func(srv *httptest.Server) credentials.TransportCredentials {
+		cfg := newTLSConfig(srv)
+		cfg.NextProtos = []string{"http/1.1"}
+		return credentials.NewTLS(cfg)
+	}
+
+	cases := map[string]struct {
+		srv     *httptest.Server
+		opts    *transport.ConnectOptions
+		wantErr bool
+	}{
+		"TLS": {
+			srv:  h1Server,
+			opts: &transport.ConnectOptions{TransportCredentials: newCreds(h1Server)},
+		},
+		"server prefers HTTP/2": {
+			srv:  h2Server,
+			opts: &transport.ConnectOptions{TLSConfig: newTLSConfig(h2Server)},
+		},
+		"unknown authority": {
+			srv:     h1Server,
+			opts:    &transport.ConnectOptions{},
+			wantErr: true,
+		},
+		"insecure": {
+			srv:  plainServer,
+			opts: &transport.ConnectOptions{Insecure: true},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			host := c.srv.Listener.Addr().String()
+			tr, err := transport.NewClientStream(context.Background(), host, "/service/Method", c.opts)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("should return an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+			}
+			defer tr.Close()
+
+			if err := tr.Send(context.Background(), strings.NewReader("hello")); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			if got := <-received; got != "hello" {
+				t.Errorf("expected 'hello', but got '%s'", got)
+			}
+		})
+	}
+}
+
+func TestClientStreamCancel(t *testing.T) {
+	closeErrs := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		// The server never responds, so reads of the client are blocked until ctx is done.
+		closeErrs <- wstest.Drain(conn)
+	}))
+	defer srv.Close()
+
+	cases := map[string]struct {
+		newContext  func() (context.Context, context.CancelFunc)
+		expectedErr error
+	}{
+		"canceled": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expectedErr: context.Canceled,
+		},
+		"deadline exceeded": {
+			newContext: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := c.newContext()
+			defer cancel()
+
+			tr, err := transport.NewClientStream(context.Background(), srv.Listener.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true})
+			if err != nil {
+				t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+			}
+			defer tr.Close()
+
+			if err := tr.Send(ctx, strings.NewReader("hello")); err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			if _, err := tr.Receive(ctx); err != c.expectedErr {
+				t.Errorf("expected error is '%s', but got '%v'", c.expectedErr, err)
+			}
+
+			err = <-closeErrs
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("the server should receive a close frame, but got '%s'", err)
+			}
+			if reason := err.(*websocket.CloseError).Text; reason != c.expectedErr.Error() {
+				t.Errorf("expected close reason is '%s', but got '%s'", c.expectedErr, reason)
+			}
+
+			if err := tr.Send(ctx, strings.NewReader("hello")); err == nil {
+				t.Errorf("Send after cancellation should return an error, but got nil")
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestConnectOptions(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("content-type", "application/grpc-web+proto")
+			return
+		}
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		conn.Close()
+	})
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+	creds := credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "example.com", NextProtos: []string{"http/1.1"}})
+
+	var dialed []string
+	contextDialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	cases := map[string]struct {
+		srv  *httptest.Server
+		opts *transport.ConnectOptions
+	}{
+		"insecure": {
+			srv:  plainServer,
+			opts: &transport.ConnectOptions{Insecure: true, ContextDialer: contextDialer},
+		},
+		"TLS": {
+			srv:  tlsServer,
+			opts: &transport.ConnectOptions{TransportCredentials: creds, ContextDialer: contextDialer},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run("ContextDialer/"+name, func(t *testing.T) {
+			dialed = nil
+			host := c.srv.Listener.Addr().String()
+
+			tr := transport.NewUnary(host, c.opts)
+			defer tr.Close()
+			_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+			if err != nil {
+				t.Fatalf("Send should not return an error, but got '%s'", err)
+			}
+			body.Close()
+
+			stm, err := transport.NewClientStream(context.Background(), host, "/service/Method", c.opts)
+			if err != nil {
+				t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+			}
+			stm.Close()
+
+			if len(dialed) != 2 || dialed[0] != host || dialed[1] != host {
+				t.Errorf("ContextDialer should be used by both transports, but dialed to %v", dialed)
+			}
+		})
+	}
+
+	t.Run("HTTPClient", func(t *testing.T) {
+		var called bool
+		client := &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				called = true
+				return http.DefaultTransport.RoundTrip(r)
+			}),
+		}
+		tr := transport.NewUnary(plainServer.Listener.Addr().String(), &transport.ConnectOptions{Insecure: true, HTTPClient: client})
+		defer tr.Close()
+		_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		body.Close()
+		if !called {
+			t.Errorf("HTTPClient should be used")
+		}
+	})
+
+	t.Run("TransportCredentials bypass proxies", func(t *testing.T) {
+		client := transport.NewHTTPClient(&transport.ConnectOptions{TransportCredentials: creds})
+		if client.Transport.(*http.Transport).Proxy != nil {
+			t.Errorf("the proxy should not be used with TransportCredentials")
+		}
+	})
+
+	t.Run("WebSocketDialer", func(t *testing.T) {
+		var called bool
+		d := &websocket.Dialer{
+			NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				called = true
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+		stm, err := transport.NewClientStream(context.Background(), plainServer.Listener.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true, WebSocketDialer: d})
+		if err != nil {
+			t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+		}
+		stm.Close()
+		if !called {
+			t.Errorf("WebSocketDialer should be used")
+		}
+	})
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	cases := map[string]struct {
+		statusCode   int
+		contentType  string
+		body         string
+		expectedCode codes.Code
+		expectedBody string
+	}{
+		"not found": {
+			statusCode:   http.StatusNotFound,
+			contentType:  "text/html",
+			body:         "<html>not found</html>",
+			expectedCode: codes.Unimplemented,
+			expectedBody: "<html>not found</html>",
+		},
+		"bad gateway": {
+			statusCode:   http.StatusBadGateway,
+			contentType:  "text/plain",
+			expectedCode: codes.Unavailable,
+		},
+		"service unavailable": {
+			statusCode:   http.StatusServiceUnavailable,
+			contentType:  "text/plain",
+			expectedCode: codes.Unavailable,
+		},
+		"unauthorized": {
+			statusCode:   http.StatusUnauthorized,
+			expectedCode: codes.Unauthenticated,
+		},
+		"forbidden": {
+			statusCode:   http.StatusForbidden,
+			expectedCode: codes.PermissionDenied,
+		},
+		"bad request": {
+			statusCode:   http.StatusBadRequest,
+			expectedCode: codes.Internal,
+		},
+		"unknown status": {
+			statusCode:   http.StatusTeapot,
+			expectedCode: codes.Unknown,
+		},
+		"not gRPC-Web content-type": {
+			statusCode:   http.StatusOK,
+			contentType:  "text/html",
+			body:         strings.Repeat("a", 1000),
+			expectedCode: codes.Unknown,
+			expectedBody: strings.Repeat("a", 512),
+		},
+		"native gRPC content-type": {
+			statusCode:   http.StatusOK,
+			contentType:  "application/grpc+proto",
+			expectedCode: codes.Unknown,
+		},
+		"gRPC-like content-type": {
+			statusCode:   http.StatusOK,
+			contentType:  "application/grpcfoo",
+			expectedCode: codes.Unknown,
+		},
+		"gRPC-Web-like content-type": {
+			statusCode:   http.StatusOK,
+			contentType:  "application/grpc-webfoo",
+			expectedCode: codes.Unknown,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", c.contentType)
+				w.WriteHeader(c.statusCode)
+				w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			tr := transport.NewUnary(srv.Listener.Addr().String(), &transport.ConnectOptions{Insecure: true})
+			defer tr.Close()
+			_, _, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+			var herr *transport.HTTPStatusError
+			if !errors.As(err, &herr) {
+				t.Fatalf("expected error is *transport.HTTPStatusError, but got '%v'", err)
+			}
+			if herr.StatusCode != c.statusCode {
+				t.Errorf("expected status code is %d, but got %d", c.statusCode, herr.StatusCode)
+			}
+			if string(herr.Body) != c.expectedBody {
+				t.Errorf("expected body is '%s', but got '%s'", c.expectedBody, herr.Body)
+			}
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected code is %s, but got %s", c.expectedCode, code)
+			}
+		})
+	}
+
+	t.Run("trailers-only", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("grpc-status", "5")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		tr := transport.NewUnary(srv.Listener.Addr().String(), &transport.ConnectOptions{Insecure: true})
+		defer tr.Close()
+		_, body, err := tr.Send(context.Background(), "/service/Method", "application/grpc-web+proto", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("Send should not return an error, but got '%s'", err)
+		}
+		body.Close()
+	})
+
+	t.Run("WebSocket handshake", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		_, err := transport.NewClientStream(context.Background(), srv.Listener.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true})
+		var herr *transport.HTTPStatusError
+		if !errors.As(err, &herr) {
+			t.Fatalf("expected error is *transport.HTTPStatusError, but got '%v'", err)
+		}
+		if code := herr.GRPCStatus().Code(); code != codes.Unavailable {
+			t.Errorf("expected code is %s, but got %s", codes.Unavailable, code)
+		}
+	})
+}
+
+func TestClientStreamHandshakeCancel(t *testing.T) {
+	// The server accepts connections, but never responds to the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen should not return an error, but got '%s'", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.NewClientStream(ctx, ln.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error is context.DeadlineExceeded, but got '%v'", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("NewClientStream should return when ctx is done")
+	}
+}
+
+func TestClientStreamHeaderError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		// The connection is lost before the header is sent.
+		conn.UnderlyingConn().Close()
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStream(context.Background(), srv.Listener.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	_, err = tr.Receive(context.Background())
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("expected status code: %s, but got %s ('%v')", codes.Unavailable, code, err)
+	}
+	if !strings.Contains(err.Error(), "failed to read response header") {
+		t.Errorf("the error should be caused by reading the header, but got '%s'", err)
+	}
+}
+
+func TestClientStreamConnectionReset(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := wstest.Upgrade(t, w, r)
+		if conn == nil {
+			return
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		conn.WriteMessage(websocket.BinaryMessage, []byte("Content-Type: application/grpc-web+proto\r\n"))
+		// Reset the connection in the middle of the stream.
+		tcpConn := conn.UnderlyingConn().(*net.TCPConn)
+		tcpConn.SetLinger(0)
+		tcpConn.Close()
+	}))
+	defer srv.Close()
+
+	tr, err := transport.NewClientStream(context.Background(), srv.Listener.Addr().String(), "/service/Method", &transport.ConnectOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("NewClientStream should not return an error, but got '%s'", err)
+	}
+	defer tr.Close()
+
+	_, err = tr.Receive(context.Background())
+	if errors.Is(err, io.EOF) {
+		t.Fatalf("the reset should not be regarded as the end of the stream, but got '%s'", err)
+	}
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("expected status code: %s, but got %s ('%v')", codes.Unavailable, code, err)
+	}
+}